@@ -14,13 +14,16 @@ func main() {
 			return
 		}
 		if apiErr, ok := err.(errors.ApiError); ok {
-			c.AbortWithStatusJSON(apiErr.GetStatus(),
-				map[string]interface{}{
-					"status":   apiErr.GetStatus(),
-					"error":    apiErr.Error(),
-					"service":  service,
-					"errorKey": apiErr.GetKey(),
-				})
+			body := map[string]interface{}{
+				"status":   apiErr.GetStatus(),
+				"error":    apiErr.Error(),
+				"service":  service,
+				"errorKey": apiErr.GetKey(),
+			}
+			if fieldErr, ok := err.(errors.FieldErrors); ok {
+				body["fields"] = fieldErr.GetFields()
+			}
+			c.AbortWithStatusJSON(apiErr.GetStatus(), body)
 		} else {
 			c.AbortWithStatusJSON(http.StatusInternalServerError,
 				map[string]interface{}{
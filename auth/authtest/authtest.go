@@ -0,0 +1,181 @@
+// authtest 以臨時金鑰簽發測試用 token, 可指定任意 claims、過期時間與錯誤簽章
+package authtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/wayne011872/api-toolkit/auth"
+)
+
+const (
+	DefaultKid  = "authtest"
+	DefaultHost = "authtest.local"
+)
+
+type Factory struct {
+	// 驗證用設定, 可直接交給 auth.WithTokenParser 或 JwtDI
+	Conf *auth.JwtConf
+	Host string
+
+	key      *ecdsa.PrivateKey
+	wrongKey *ecdsa.PrivateKey
+}
+
+func NewFactory() (*Factory, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	wrongKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := auth.NewJwtConfWithSigner(DefaultKid, key)
+	if err != nil {
+		return nil, err
+	}
+	conf.RefreshSecret = "authtest-refresh-secret"
+	return &Factory{
+		Conf:     conf,
+		Host:     DefaultHost,
+		key:      key,
+		wrongKey: wrongKey,
+	}, nil
+}
+
+// 建立失敗時直接 t.Fatal
+func New(t testing.TB) *Factory {
+	t.Helper()
+	f, err := NewFactory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+type tokenOpts struct {
+	host      string
+	issuedAt  time.Time
+	expiresIn time.Duration
+	noExp     bool
+	notBefore time.Time
+	header    map[string]interface{}
+	wrongSign bool
+}
+
+type TokenOption func(*tokenOpts)
+
+// 過期時間, 負值即為已過期的 token
+func ExpiresIn(d time.Duration) TokenOption {
+	return func(o *tokenOpts) {
+		o.expiresIn = d
+	}
+}
+
+func Expired() TokenOption {
+	return ExpiresIn(-time.Minute)
+}
+
+func WithoutExp() TokenOption {
+	return func(o *tokenOpts) {
+		o.noExp = true
+	}
+}
+
+func IssuedAt(t time.Time) TokenOption {
+	return func(o *tokenOpts) {
+		o.issuedAt = t
+	}
+}
+
+func NotBefore(t time.Time) TokenOption {
+	return func(o *tokenOpts) {
+		o.notBefore = t
+	}
+}
+
+func WithHost(host string) TokenOption {
+	return func(o *tokenOpts) {
+		o.host = host
+	}
+}
+
+// 覆寫或加入 header 欄位, 例如 kid、usa
+func WithHeader(key string, value interface{}) TokenOption {
+	return func(o *tokenOpts) {
+		o.header[key] = value
+	}
+}
+
+func WithUsage(usage string) TokenOption {
+	return WithHeader(auth.HeaderUsage, usage)
+}
+
+// 以另一把金鑰簽章, header 與 claims 不變
+func WrongSignature() TokenOption {
+	return func(o *tokenOpts) {
+		o.wrongSign = true
+	}
+}
+
+// 簽發 token, 不會修改傳入的 claims
+func (f *Factory) Token(claims map[string]interface{}, opts ...TokenOption) (string, error) {
+	o := &tokenOpts{
+		host:      f.Host,
+		issuedAt:  time.Now(),
+		expiresIn: 15 * time.Minute,
+		header:    map[string]interface{}{"kid": f.Conf.GetKid()},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	data := jwt.MapClaims{
+		auth.ClaimIssuer: o.host,
+		"iat":            o.issuedAt.Unix(),
+	}
+	if !o.noExp {
+		data[auth.ClaimExpire] = o.issuedAt.Add(o.expiresIn).Unix()
+	}
+	if !o.notBefore.IsZero() {
+		data["nbf"] = o.notBefore.Unix()
+	}
+	for k, v := range claims {
+		data[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, data)
+	for k, v := range o.header {
+		token.Header[k] = v
+	}
+	key := f.key
+	if o.wrongSign {
+		key = f.wrongKey
+	}
+	return token.SignedString(key)
+}
+
+func (f *Factory) MustToken(t testing.TB, claims map[string]interface{}, opts ...TokenOption) string {
+	t.Helper()
+	tokenStr, err := f.Token(claims, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenStr
+}
+
+// Authorization header 的值
+func (f *Factory) Bearer(t testing.TB, claims map[string]interface{}, opts ...TokenOption) string {
+	t.Helper()
+	return "Bearer " + f.MustToken(t, claims, opts...)
+}
+
+// 不經過簽章, 直接由 claims 建立 ReqUser
+func (f *Factory) User(claims map[string]interface{}) auth.ReqUserExt {
+	return auth.NewReqUserFromClaims(claims, "")
+}
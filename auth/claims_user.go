@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	ClaimIssuer   = "iss"
+	ClaimUserId   = "sub"
+	ClaimAccount  = "acc"
+	ClaimName     = "nam"
+	ClaimPerms    = "per"
+	ClaimTenantId = "tid"
+	ClaimEmail    = "email"
+	ClaimScope    = "scope"
+	ClaimTokenId  = "jti"
+	ClaimExpire   = "exp"
+	ClaimActor    = "act"
+
+	HeaderUsage = "usa"
+	// GetAccessToken 簽發的資源存取 token
+	UsageAccess = "access"
+)
+
+// 可由 ReqUser 轉型取得的延伸資訊
+type ReqUserExt interface {
+	ReqUser
+	GetTenantId() string
+	GetEmail() string
+	GetScopes() []string
+	GetTokenId() string
+	GetExpiresAt() time.Time
+	GetClaim(key string) (interface{}, bool)
+	// 代理 (impersonation) 時為實際操作者, 否則為 nil
+	GetActor() *Actor
+}
+
+// RFC 8693 act claim
+type Actor struct {
+	Sub     string `json:"sub"`
+	Account string `json:"acc,omitempty"`
+	Name    string `json:"nam,omitempty"`
+	Act     *Actor `json:"act,omitempty"`
+}
+
+// 由近到遠列出所有代理者
+func (a *Actor) Chain() []*Actor {
+	var chain []*Actor
+	for act := a; act != nil; act = act.Act {
+		chain = append(chain, act)
+	}
+	return chain
+}
+
+func parseActor(v interface{}) *Actor {
+	switch act := v.(type) {
+	case *Actor:
+		return act
+	case Actor:
+		return &act
+	case map[string]interface{}:
+		a := &Actor{}
+		a.Sub, _ = act[ClaimUserId].(string)
+		a.Account, _ = act[ClaimAccount].(string)
+		a.Name, _ = act[ClaimName].(string)
+		a.Act = parseActor(act[ClaimActor])
+		return a
+	}
+	return nil
+}
+
+// 將 actor 設為 data 的代理者, actor 本身若也在代理中會保留整條鏈
+func Impersonate(data map[string]interface{}, actor ReqUser) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	act := &Actor{
+		Sub:     actor.GetId(),
+		Account: actor.GetAccount(),
+		Name:    actor.GetName(),
+	}
+	if ext, ok := actor.(ReqUserExt); ok {
+		act.Act = ext.GetActor()
+	}
+	data[ClaimActor] = act
+	return data
+}
+
+func claimString(claims map[string]interface{}, key string) string {
+	s, _ := claims[key].(string)
+	return s
+}
+
+func claimStrings(v interface{}) []string {
+	switch ary := v.(type) {
+	case string:
+		if ary == "" {
+			return nil
+		}
+		return []string{ary}
+	case []string:
+		return ary
+	case []ApiPerm:
+		result := make([]string, len(ary))
+		for i, p := range ary {
+			result[i] = string(p)
+		}
+		return result
+	case ApiPerm:
+		return []string{string(ary)}
+	case []interface{}:
+		var result []string
+		for _, s := range ary {
+			if str, ok := s.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func NewReqUserFromToken(token *jwt.Token) ReqUserExt {
+	claims, _ := token.Claims.(jwt.MapClaims)
+	usage, _ := token.Header[HeaderUsage].(string)
+	return NewReqUserFromClaims(claims, usage)
+}
+
+func NewReqUserFromClaims(claims map[string]interface{}, usage string) ReqUserExt {
+	u := &claimsReqUser{
+		reqUserImpl: reqUserImpl{
+			host:    claimString(claims, ClaimIssuer),
+			uid:     claimString(claims, ClaimUserId),
+			account: claimString(claims, ClaimAccount),
+			name:    claimString(claims, ClaimName),
+			roles:   claimStrings(claims[ClaimPerms]),
+			usage:   usage,
+		},
+		claims: claims,
+	}
+	if scope, ok := claims[ClaimScope].(string); ok {
+		u.scopes = strings.Fields(scope)
+	} else {
+		u.scopes = claimStrings(claims[ClaimScope])
+	}
+	u.amr, u.authTime = GetMfaFromClaims(claims)
+	return u
+}
+
+type claimsReqUser struct {
+	reqUserImpl
+	claims   map[string]interface{}
+	scopes   []string
+	amr      []string
+	authTime time.Time
+}
+
+func (u *claimsReqUser) GetTenantId() string {
+	return claimString(u.claims, ClaimTenantId)
+}
+
+func (u *claimsReqUser) GetEmail() string {
+	return claimString(u.claims, ClaimEmail)
+}
+
+func (u *claimsReqUser) GetScopes() []string {
+	return u.scopes
+}
+
+func (u *claimsReqUser) GetTokenId() string {
+	return claimString(u.claims, ClaimTokenId)
+}
+
+func (u *claimsReqUser) GetExpiresAt() time.Time {
+	if sec, ok := claimInt64(u.claims[ClaimExpire]); ok {
+		return time.Unix(sec, 0)
+	}
+	return time.Time{}
+}
+
+func (u *claimsReqUser) GetClaim(key string) (interface{}, bool) {
+	v, ok := u.claims[key]
+	return v, ok
+}
+
+func (u *claimsReqUser) GetActor() *Actor {
+	return parseActor(u.claims[ClaimActor])
+}
+
+func (u *claimsReqUser) GetAmr() []string {
+	return u.amr
+}
+
+func (u *claimsReqUser) GetAuthTime() time.Time {
+	return u.authTime
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseActorNested(t *testing.T) {
+	act := parseActor(map[string]interface{}{
+		ClaimUserId:  "support",
+		ClaimAccount: "support@example.com",
+		ClaimActor: map[string]interface{}{
+			ClaimUserId: "admin",
+			ClaimActor:  map[string]interface{}{ClaimUserId: "root"},
+		},
+	})
+	var subs []string
+	for _, a := range act.Chain() {
+		subs = append(subs, a.Sub)
+	}
+	if !reflect.DeepEqual(subs, []string{"support", "admin", "root"}) || act.Account != "support@example.com" {
+		t.Fatalf("actor %+v, chain %v", act, subs)
+	}
+	if parseActor(nil) != nil || parseActor("admin") != nil {
+		t.Fatal("non-object act parsed")
+	}
+}
+
+// 代理者本身也在代理中時, 簽發的 token 保留整條鏈, 事件同時記錄兩種身分
+func TestImpersonateEvent(t *testing.T) {
+	j, err := NewEphemeralJwtConf("kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func(data map[string]interface{}) ReqUserExt {
+		t.Helper()
+		tokenStr, err := j.GetToken("host", data, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := j.ParseToken(*tokenStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewReqUserFromToken(token)
+	}
+	admin := issue(Impersonate(map[string]interface{}{ClaimUserId: "admin"}, NewReqUser("host", "root", "", "", nil, "")))
+	user := issue(Impersonate(map[string]interface{}{ClaimUserId: "u1"}, admin))
+	if user.GetId() != "u1" || user.GetActor() == nil || len(user.GetActor().Chain()) != 2 {
+		t.Fatalf("actor %+v", user.GetActor())
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/me", nil)
+	SetReqUserToGin(c, user)
+	e := NewAuthEventFromGin(c, EventPermDenied, "")
+	if e.UserId != "u1" || e.Actor != "admin" || !reflect.DeepEqual(e.ActorChain, []string{"admin", "root"}) {
+		t.Fatalf("event %+v", e)
+	}
+
+	rec := &eventRecorder{}
+	j.AddEventListener(rec)
+	issue(Impersonate(map[string]interface{}{ClaimUserId: "u2"}, admin))
+	if e := rec.last(); e == nil || e.UserId != "u2" || e.Actor != "admin" {
+		t.Fatalf("token event %+v", e)
+	}
+}
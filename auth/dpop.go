@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	DPoPHeaderKey = "DPoP"
+	ClaimCnf      = "cnf"
+
+	dpopTyp = "dpop+jwt"
+)
+
+// RFC 7638 JWK thumbprint (SHA-256, base64url)
+func JwkThumbprint(pub crypto.PublicKey) (string, error) {
+	var members string
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		members = `{"crv":"` + k.Curve.Params().Name +
+			`","kty":"EC","x":"` + b64Fixed(k.X, size) +
+			`","y":"` + b64Fixed(k.Y, size) + `"}`
+	case *rsa.PublicKey:
+		members = `{"e":"` + base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()) +
+			`","kty":"RSA","n":"` + base64.RawURLEncoding.EncodeToString(k.N.Bytes()) + `"}`
+	case ed25519.PublicKey:
+		members = `{"crv":"Ed25519","kty":"OKP","x":"` + base64.RawURLEncoding.EncodeToString(k) + `"}`
+	default:
+		return "", errors.New("unsupported key type")
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func b64Fixed(n *big.Int, size int) string {
+	b := make([]byte, size)
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(b))
+}
+
+func b64BigInt(v interface{}) (*big.Int, error) {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return nil, errors.New("invalid jwk member")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func parseJwk(v interface{}) (crypto.PublicKey, error) {
+	jwk, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("missing jwk")
+	}
+	if _, ok := jwk["d"]; ok {
+		return nil, errors.New("jwk contains private key")
+	}
+	switch jwk["kty"] {
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := b64BigInt(jwk["x"])
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64BigInt(jwk["y"])
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := b64BigInt(jwk["n"])
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64BigInt(jwk["e"])
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+// 簽發 token 時將 data 綁定到 jkt, 之後使用此 token 都要附上同一把金鑰簽的 DPoP proof
+func SetDPoPClaims(data map[string]interface{}, jkt string) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data[ClaimCnf] = map[string]interface{}{"jkt": jkt}
+	return data
+}
+
+func GetDPoPJkt(u ReqUser) string {
+	ext, ok := u.(ReqUserExt)
+	if !ok {
+		return ""
+	}
+	v, _ := ext.GetClaim(ClaimCnf)
+	cnf, _ := v.(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	return jkt
+}
+
+type ReplayCache interface {
+	// key 為 proof 公鑰的 thumbprint; 第一次出現回傳 true, exp 之前重複出現或無法記錄時回傳 false
+	Add(key, jti string, exp time.Time) bool
+}
+
+// 最多保存 maxSize 筆未過期的紀錄, 同一把金鑰最多 maxPerKey 筆, 只淘汰已過期的紀錄
+// 已滿時拒絕新的 jti, 不會淘汰仍有效的紀錄讓 proof 可以重送; 單一金鑰用完額度只影響自己
+func NewMemReplayCache(maxSize, maxPerKey int) ReplayCache {
+	if maxSize <= 0 {
+		maxSize = 10000
+	}
+	if maxPerKey <= 0 || maxPerKey > maxSize {
+		maxPerKey = maxSize
+	}
+	return &memReplayCache{
+		maxSize:   maxSize,
+		maxPerKey: maxPerKey,
+		order:     list.New(),
+		items:     make(map[string]*list.Element),
+		perKey:    make(map[string]int),
+	}
+}
+
+type replayItem struct {
+	key string
+	jti string
+	exp time.Time
+}
+
+type memReplayCache struct {
+	lock      sync.Mutex
+	maxSize   int
+	maxPerKey int
+	order     *list.List
+	items     map[string]*list.Element
+	perKey    map[string]int
+}
+
+func (c *memReplayCache) Add(key, jti string, exp time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	for e := c.order.Front(); e != nil && !now.Before(e.Value.(*replayItem).exp); e = c.order.Front() {
+		c.remove(e)
+	}
+	id := key + ":" + jti
+	if e, ok := c.items[id]; ok {
+		if now.Before(e.Value.(*replayItem).exp) {
+			return false
+		}
+		c.remove(e)
+	}
+	// 加入順序與 exp 順序不一定相同, 已滿時再找出其他已過期的紀錄
+	if c.order.Len() >= c.maxSize || c.perKey[key] >= c.maxPerKey {
+		for e := c.order.Front(); e != nil; {
+			next := e.Next()
+			if !now.Before(e.Value.(*replayItem).exp) {
+				c.remove(e)
+			}
+			e = next
+		}
+		if c.order.Len() >= c.maxSize || c.perKey[key] >= c.maxPerKey {
+			return false
+		}
+	}
+	c.items[id] = c.order.PushBack(&replayItem{key: key, jti: jti, exp: exp})
+	c.perKey[key]++
+	return true
+}
+
+func (c *memReplayCache) remove(e *list.Element) {
+	item := e.Value.(*replayItem)
+	c.order.Remove(e)
+	delete(c.items, item.key+":"+item.jti)
+	if c.perKey[item.key]--; c.perKey[item.key] <= 0 {
+		delete(c.perKey, item.key)
+	}
+}
+
+type DPoPConf struct {
+	// proof 的 iat 可接受的時間範圍, 預設 1 分鐘
+	MaxAge time.Duration
+	// 預設 NewMemReplayCache(10000, 1000)
+	ReplayCache ReplayCache
+	// 來自這些位址的請求才採用 X-Forwarded-Proto/X-Forwarded-Host 比對 htu
+	TrustedProxies []netip.Prefix
+}
+
+type DPoPVerifier interface {
+	// jkt 為 token 的 cnf.jkt, proof 金鑰不符時不記錄 jti
+	// accessToken 與 jkt 為空時不檢查 (簽發 token 時), 回傳 proof 公鑰的 thumbprint
+	Verify(req *http.Request, proof, accessToken, jkt string) (string, error)
+}
+
+func NewDPoPVerifier(conf DPoPConf) DPoPVerifier {
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = time.Minute
+	}
+	if conf.ReplayCache == nil {
+		conf.ReplayCache = NewMemReplayCache(10000, 1000)
+	}
+	return &dpopVerifier{conf: conf}
+}
+
+type dpopVerifier struct {
+	conf DPoPConf
+}
+
+func (v *dpopVerifier) isTrustedProxy(req *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, p := range v.conf.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *dpopVerifier) requestHtu(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	host := req.Host
+	if v.isTrustedProxy(req) {
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+		if h := req.Header.Get("X-Forwarded-Host"); h != "" {
+			host = h
+		}
+	}
+	return scheme + "://" + host + req.URL.Path
+}
+
+func (v *dpopVerifier) Verify(req *http.Request, proof, accessToken, expectedJkt string) (string, error) {
+	if proof == "" {
+		return "", errors.New("missing dpop proof")
+	}
+	var jkt string
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(proof, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopTyp {
+			return nil, errors.New("invalid typ")
+		}
+		switch token.Method.(type) {
+		case *jwt.SigningMethodECDSA, *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, errors.New("unsupported alg")
+		}
+		pub, err := parseJwk(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if jkt, err = JwkThumbprint(pub); err != nil {
+			return nil, err
+		}
+		return pub, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", errors.New("invalid dpop proof")
+	}
+	if htm, _ := claims["htm"].(string); htm != req.Method {
+		return "", errors.New("htm not match")
+	}
+	if htu, _ := claims["htu"].(string); stripQuery(htu) != v.requestHtu(req) {
+		return "", errors.New("htu not match")
+	}
+	iat, ok := claimInt64(claims["iat"])
+	if !ok {
+		return "", errors.New("missing iat")
+	}
+	issuedAt := time.Unix(iat, 0)
+	if d := time.Since(issuedAt); d > v.conf.MaxAge || d < -v.conf.MaxAge {
+		return "", errors.New("dpop proof expired")
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if ath, _ := claims["ath"].(string); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", errors.New("ath not match")
+		}
+	}
+	if expectedJkt != "" && jkt != expectedJkt {
+		return "", errors.New("dpop key does not match token cnf")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", errors.New("missing jti")
+	}
+	// 所有檢查都通過後才記錄, 無效的 proof 不會佔用 replay cache
+	if !v.conf.ReplayCache.Add(jkt, jti, issuedAt.Add(v.conf.MaxAge)) {
+		return "", errors.New("dpop proof replayed")
+	}
+	return jkt, nil
+}
+
+func stripQuery(u string) string {
+	for i, ch := range u {
+		if ch == '?' || ch == '#' {
+			return u[:i]
+		}
+	}
+	return u
+}
+
+// 在 token endpoint 驗證 client 的 DPoP proof 並將 data 綁定到該金鑰
+// 應在驗證過帳號密碼等憑證後才呼叫, 避免未驗證的請求佔用 replay cache
+func BindDPoP(v DPoPVerifier, req *http.Request, data map[string]interface{}) (map[string]interface{}, error) {
+	jkt, err := v.Verify(req, req.Header.Get(DPoPHeaderKey), "", "")
+	if err != nil {
+		return nil, err
+	}
+	return SetDPoPClaims(data, jkt), nil
+}
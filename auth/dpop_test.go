@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func newDPoPProof(t *testing.T, key *ecdsa.PrivateKey, method, htu, accessToken, jti string) string {
+	t.Helper()
+	claims := jwt.MapClaims{"htm": method, "htu": htu, "iat": time.Now().Unix(), "jti": jti}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = dpopTyp
+	token.Header["jwk"] = map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   b64Fixed(key.X, 32),
+		"y":   b64Fixed(key.Y, 32),
+	}
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestDPoPRoundTrip(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewDPoPVerifier(DPoPConf{})
+	j, err := NewEphemeralJwtConf("kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenReq := httptest.NewRequest("POST", "http://api.local/token", nil)
+	tokenReq.Header.Set(DPoPHeaderKey, newDPoPProof(t, key, "POST", "http://api.local/token", "", "jti-1"))
+	data, err := BindDPoP(v, tokenReq, map[string]interface{}{ClaimUserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenStr, err := j.GetToken("host", data, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := j.ParseToken(*tokenStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt := GetDPoPJkt(NewReqUserFromToken(token))
+	if want, _ := JwkThumbprint(key.Public()); jkt != want {
+		t.Fatalf("jkt %q, want %q", jkt, want)
+	}
+
+	req := httptest.NewRequest("GET", "http://api.local/users?x=1", nil)
+	proof := newDPoPProof(t, key, "GET", "http://api.local/users", *tokenStr, "jti-2")
+	if got, err := v.Verify(req, proof, *tokenStr, jkt); err != nil || got != jkt {
+		t.Fatalf("Verify = %q, %v", got, err)
+	}
+	if _, err = v.Verify(req, proof, *tokenStr, jkt); err == nil {
+		t.Fatal("replayed proof accepted")
+	}
+	other := newDPoPProof(t, key, "GET", "http://api.local/users", "other-token", "jti-3")
+	if _, err = v.Verify(req, other, *tokenStr, jkt); err == nil {
+		t.Fatal("proof for another token accepted")
+	}
+	post := newDPoPProof(t, key, "POST", "http://api.local/users", *tokenStr, "jti-4")
+	if _, err = v.Verify(req, post, *tokenStr, jkt); err == nil {
+		t.Fatal("proof for another method accepted")
+	}
+}
+
+func TestMemReplayCacheKeepsLiveEntries(t *testing.T) {
+	c := NewMemReplayCache(2, 0)
+	now := time.Now()
+	if !c.Add("k", "a", now.Add(time.Minute)) || !c.Add("k", "b", now.Add(time.Minute)) {
+		t.Fatal("first use rejected")
+	}
+	if c.Add("k", "c", now.Add(time.Minute)) {
+		t.Fatal("full cache accepted a new jti")
+	}
+	if c.Add("k", "a", now.Add(time.Minute)) {
+		t.Fatal("live jti replayed after the cache was full")
+	}
+
+	c = NewMemReplayCache(2, 0)
+	c.Add("k", "live", now.Add(time.Minute))
+	c.Add("k", "expired", now.Add(-time.Second))
+	if !c.Add("k", "new", now.Add(time.Minute)) {
+		t.Fatal("expired entry behind a live one was not evicted")
+	}
+	if c.Add("k", "live", now.Add(time.Minute)) {
+		t.Fatal("live jti evicted")
+	}
+}
+
+func TestMemReplayCachePerKeyLimit(t *testing.T) {
+	c := NewMemReplayCache(10, 2)
+	exp := time.Now().Add(time.Minute)
+	if !c.Add("attacker", "1", exp) || !c.Add("attacker", "2", exp) {
+		t.Fatal("first use rejected")
+	}
+	if c.Add("attacker", "3", exp) {
+		t.Fatal("key exceeded its quota")
+	}
+	if !c.Add("user", "1", exp) {
+		t.Fatal("another key blocked by the attacker's quota")
+	}
+}
+
+// 金鑰與 token cnf 不符的 proof 不會佔用 replay cache
+func TestDPoPVerifyMismatchedKeyNotRecorded(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt, _ := JwkThumbprint(key.Public())
+	v := NewDPoPVerifier(DPoPConf{ReplayCache: NewMemReplayCache(1, 0)})
+	req := httptest.NewRequest("GET", "http://api.local/users", nil)
+	proof := newDPoPProof(t, key, "GET", "http://api.local/users", "token", "jti-1")
+	if _, err = v.Verify(req, proof, "token", "other-jkt"); err == nil {
+		t.Fatal("proof for another key accepted")
+	}
+	if _, err = v.Verify(req, proof, "token", jkt); err != nil {
+		t.Fatalf("valid proof rejected after a mismatched one: %v", err)
+	}
+}
+
+func TestDPoPForwardedHeadersNeedTrustedProxy(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newReq := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://10.0.0.5/users", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "api.example.com")
+		return req
+	}
+	untrusted := NewDPoPVerifier(DPoPConf{})
+	proof := newDPoPProof(t, key, "GET", "https://api.example.com/users", "", "jti-1")
+	if _, err = untrusted.Verify(newReq(), proof, "", ""); err == nil {
+		t.Fatal("forwarded headers trusted without a proxy list")
+	}
+	trusted := NewDPoPVerifier(DPoPConf{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}})
+	if _, err = trusted.Verify(newReq(), proof, "", ""); err != nil {
+		t.Fatalf("forwarded headers from a trusted proxy: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type AuthEventType string
+
+const (
+	EventTokenIssued  = AuthEventType("token_issued")
+	EventTokenRefresh = AuthEventType("token_refresh")
+	EventTokenRevoke  = AuthEventType("token_revoke")
+	EventAuthFailure  = AuthEventType("auth_failure")
+	EventPermDenied   = AuthEventType("permission_denied")
+	EventMfaSuccess   = AuthEventType("mfa_success")
+	EventMfaFailure   = AuthEventType("mfa_failure")
+	EventOneTimeIssue = AuthEventType("onetime_issued")
+	EventOneTimeUsed  = AuthEventType("onetime_used")
+	EventLockout      = AuthEventType("account_locked")
+
+	RequestIdHeaderKey = "X-Request-Id"
+)
+
+type AuthEvent struct {
+	Type      AuthEventType `json:"type"`
+	Time      time.Time     `json:"time"`
+	UserId    string        `json:"userId,omitempty"`
+	Account   string        `json:"account,omitempty"`
+	Route     string        `json:"route,omitempty"`
+	Method    string        `json:"method,omitempty"`
+	IP        string        `json:"ip,omitempty"`
+	RequestId string        `json:"requestId,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	// 實際的錯誤原因, Reason 為回應給 client 的訊息
+	Detail string `json:"detail,omitempty"`
+	// 代理 (impersonation) 時的實際操作者, ActorChain 由近到遠列出整條代理鏈的 sub
+	Actor      string   `json:"actor,omitempty"`
+	ActorChain []string `json:"actorChain,omitempty"`
+}
+
+func NewAuthEvent(typ AuthEventType, user ReqUser) *AuthEvent {
+	e := &AuthEvent{Type: typ, Time: time.Now()}
+	if user != nil {
+		e.UserId = user.GetId()
+		e.Account = user.GetAccount()
+		if ext, ok := user.(ReqUserExt); ok {
+			e.setActor(ext.GetActor())
+		}
+	}
+	return e
+}
+
+func (e *AuthEvent) setActor(act *Actor) {
+	if act == nil {
+		return
+	}
+	e.Actor = act.Sub
+	e.ActorChain = nil
+	for _, a := range act.Chain() {
+		e.ActorChain = append(e.ActorChain, a.Sub)
+	}
+}
+
+// 由請求帶入 route/IP/request id 與目前的 ReqUser
+func NewAuthEventFromGin(c *gin.Context, typ AuthEventType, reason string) *AuthEvent {
+	var user ReqUser
+	if u, ok := c.Get(_KEY_USER_INFO); ok {
+		user, _ = u.(ReqUser)
+	}
+	e := NewAuthEvent(typ, user)
+	e.Route = c.FullPath()
+	e.Method = c.Request.Method
+	e.IP = c.ClientIP()
+	e.RequestId = c.GetHeader(RequestIdHeaderKey)
+	e.Reason = reason
+	return e
+}
+
+type AuthEventListener interface {
+	OnAuthEvent(e *AuthEvent)
+}
+
+type AuthEventListenerFunc func(e *AuthEvent)
+
+func (f AuthEventListenerFunc) OnAuthEvent(e *AuthEvent) {
+	f(e)
+}
+
+type AuthEventListeners []AuthEventListener
+
+func (ls AuthEventListeners) OnAuthEvent(e *AuthEvent) {
+	for _, l := range ls {
+		if l != nil {
+			l.OnAuthEvent(e)
+		}
+	}
+}
+
+type PromAuthEventListener interface {
+	AuthEventListener
+	prometheus.Collector
+}
+
+// 回傳的 listener 同時是 prometheus.Collector, 可交給 SetPromhttp 註冊
+func NewPromAuthEventListener(namespace string) PromAuthEventListener {
+	return &promAuthEventListener{
+		CounterVec: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_events_total",
+			Help:      "Number of authentication events by type and reason.",
+		}, []string{"type", "reason"}),
+	}
+}
+
+type promAuthEventListener struct {
+	*prometheus.CounterVec
+}
+
+func (l *promAuthEventListener) OnAuthEvent(e *AuthEvent) {
+	l.WithLabelValues(string(e.Type), e.Reason).Inc()
+}
+
+type FileAuthEventListener interface {
+	AuthEventListener
+	io.Closer
+}
+
+// 每個事件以一行 JSON 附加到檔案
+func NewFileAuthEventListener(path string) (FileAuthEventListener, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuthEventListener{file: f}, nil
+}
+
+type fileAuthEventListener struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func (l *fileAuthEventListener) OnAuthEvent(e *AuthEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.file.Write(append(b, '\n'))
+}
+
+func (l *fileAuthEventListener) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type eventRecorder struct {
+	lock   sync.Mutex
+	events []*AuthEvent
+}
+
+func (r *eventRecorder) OnAuthEvent(e *AuthEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) types() []AuthEventType {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	types := make([]AuthEventType, len(r.events))
+	for i, e := range r.events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func (r *eventRecorder) last() *AuthEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.events) == 0 {
+		return nil
+	}
+	return r.events[len(r.events)-1]
+}
+
+func TestAccessTokenEvents(t *testing.T) {
+	j, err := NewEphemeralJwtConf("kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &eventRecorder{}
+	j.AddEventListener(rec)
+	if _, err = j.GetAccessToken("host", "billing", 42, "db", "read"); err != nil {
+		t.Fatal(err)
+	}
+	e := rec.last()
+	if e == nil || e.Type != EventTokenIssued || e.Account != "billing" || e.UserId != "42" || e.Reason != UsageAccess {
+		t.Fatalf("event %+v", e)
+	}
+}
+
+func TestOneTimeEvents(t *testing.T) {
+	ott := NewHmacOneTimeToken([]byte("secret"), NewMemOneTimeStore())
+	rec := &eventRecorder{}
+	ott.AddEventListener(rec)
+	tokenStr, err := ott.Issue(PurposeEmailVerify, "u1", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ott.Verify(PurposeEmailVerify, tokenStr); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ott.Verify(PurposeEmailVerify, tokenStr); err == nil {
+		t.Fatal("verified twice")
+	}
+	want := []AuthEventType{EventOneTimeIssue, EventOneTimeUsed, EventAuthFailure}
+	if got := rec.types(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("events %v", got)
+	}
+	if e := rec.last(); e.UserId != "u1" || e.Detail == "" {
+		t.Fatalf("failure event %+v", e)
+	}
+}
+
+func TestLockoutEvent(t *testing.T) {
+	th := NewLoginThrottle(ThrottleConf{FreeAttempts: 10, LockoutThreshold: 2}, NewMemThrottleStore())
+	rec := &eventRecorder{}
+	th.AddEventListener(rec)
+	for i := 0; i < 2; i++ {
+		if err := th.Failure(throttleContext(), "amy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := rec.last()
+	if e == nil || e.Type != EventLockout || e.Account != "amy" {
+		t.Fatalf("event %+v", e)
+	}
+}
+
+func TestBearerRejectDetail(t *testing.T) {
+	j, err := NewEphemeralJwtConf("kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &eventRecorder{}
+	m := NewGinBearAuthMid(false, WithTokenParser(j), WithEventListener(rec))
+	m.AddAuthPath("/me", http.MethodGet, true, nil)
+	m.SetApiErrorHandler(func(c *gin.Context, err error) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})
+	r := gin.New()
+	r.GET("/me", m.Handler(), func(c *gin.Context) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set(BearerAuthTokenKey, "Bearer not-a-token")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	e := rec.last()
+	if e == nil || e.Type != EventAuthFailure || e.Detail == "" || e.Detail == e.Reason {
+		t.Fatalf("event %+v", e)
+	}
+}
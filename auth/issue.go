@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	apierr "github.com/wayne011872/api-toolkit/errors"
+)
+
+const refreshTokenExp = 24 * time.Hour
+
+// JwtConf 與 PasetoConf 只差在 token 的編碼方式, 簽發與 refresh 的流程共用
+type tokenCodec interface {
+	// claims 已帶 iss/iat/exp
+	sealAccess(claims map[string]interface{}) (string, error)
+	sealRefresh(claims map[string]interface{}) (string, error)
+	// 回傳驗證後的 claims, 仍帶 iss/iat/exp
+	openRefresh(refreshToken string) (map[string]interface{}, error)
+	// 未設定 refresh 金鑰時回傳錯誤
+	refreshReady() error
+}
+
+func stampClaims(host string, data map[string]interface{}, exp time.Duration) {
+	now := time.Now()
+	data["iss"] = host
+	data["iat"] = now.Unix()
+	data["exp"] = now.Add(exp).Unix()
+}
+
+// exp 為分鐘, 0 為預設 60 分鐘, 最多 180 分鐘
+func accessTokenExp(exp uint8) time.Duration {
+	if exp <= 0 {
+		exp = 60
+	} else if exp > 180 {
+		exp = 180
+	}
+	return time.Duration(exp) * time.Minute
+}
+
+func sealAccessToken(c tokenCodec, host string, data map[string]interface{}, exp uint8) (*string, error) {
+	if data == nil {
+		return nil, errors.New("no data")
+	}
+	stampClaims(host, data, accessTokenExp(exp))
+	ss, err := c.sealAccess(data)
+	if err != nil {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+func issueToken(c tokenCodec, listeners AuthEventListeners, host string, data map[string]interface{}, exp uint8) (*string, error) {
+	ss, err := sealAccessToken(c, host, data, exp)
+	if err != nil {
+		return nil, err
+	}
+	emitTokenEvent(listeners, EventTokenIssued, data, nil, nil)
+	return ss, nil
+}
+
+func issueTokenWithRefresh(c tokenCodec, listeners AuthEventListeners, host string, data map[string]interface{}, exp uint8) (*token, error) {
+	if err := c.refreshReady(); err != nil {
+		return nil, err
+	}
+	t, err := issueToken(c, listeners, host, data, exp)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		claims[k] = v
+	}
+	stampClaims(host, claims, refreshTokenExp)
+	refreshToken, err := c.sealRefresh(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &token{AccessToken: *t, RefreshToken: refreshToken}, nil
+}
+
+func parseRefreshToken(c tokenCodec, refreshToken string) (host string, data map[string]interface{}, err error) {
+	if err = c.refreshReady(); err != nil {
+		return "", nil, err
+	}
+	if data, err = c.openRefresh(refreshToken); err != nil {
+		return "", nil, err
+	}
+	host, _ = data["iss"].(string)
+	delete(data, "iss")
+	delete(data, "iat")
+	delete(data, "exp")
+	return host, data, nil
+}
+
+// refresh 時確認 session 仍有效, 以原本的 claims 重新簽發 60 分鐘的 access token
+func refreshAccessToken(c tokenCodec, store SessionStore, listeners AuthEventListeners, refreshToken string) (*string, error) {
+	host, data, err := parseRefreshToken(c, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTokenSession(store, data); err != nil {
+		emitTokenEvent(listeners, EventAuthFailure, data, apierr.Error_Auth_Session_Invalid, err)
+		return nil, err
+	}
+	ss, err := sealAccessToken(c, host, data, 60)
+	if err != nil {
+		return nil, err
+	}
+	emitTokenEvent(listeners, EventTokenRefresh, data, nil, nil)
+	return ss, nil
+}
@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	JweAlgRsaOaep    = "RSA-OAEP"
+	JweAlgRsaOaep256 = "RSA-OAEP-256"
+	JweAlgEcdhEs     = "ECDH-ES"
+
+	jweEnc = "A256GCM"
+)
+
+// 設定後 GetToken/GetAccessToken 產生先簽章後加密的 JWE, ParseToken 會先解密
+type JweConf struct {
+	// RSA-OAEP, RSA-OAEP-256 或 ECDH-ES, 內容一律以 A256GCM 加密
+	Alg            string `yaml:"alg"`
+	PublicKeyFile  string `yaml:"publickey"`
+	PrivateKeyFile string `yaml:"privatekey"`
+	PublicKeyEnv   string `yaml:"publickey_env"`
+	PrivateKeyEnv  string `yaml:"privatekey_env"`
+	// 加密金鑰的 kid, 讓持有多把解密金鑰的接收端選擇; 預設為加密公鑰的 JWK thumbprint
+	Kid string `yaml:"kid"`
+}
+
+type jweHeader struct {
+	Alg string  `json:"alg"`
+	Enc string  `json:"enc"`
+	Cty string  `json:"cty"`
+	Kid string  `json:"kid,omitempty"`
+	Epk *jweEpk `json:"epk,omitempty"`
+}
+
+type jweEpk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *JwtConf) isJweEnabled() bool {
+	return j.Encryption.Alg != ""
+}
+
+func isJwe(tokenStr string) bool {
+	return strings.Count(tokenStr, ".") == 4
+}
+
+func (j *JwtConf) getJwePublicKey() (crypto.PublicKey, error) {
+	k, err := j.lazyKey(func() interface{} {
+		if j.jwePublicKey == nil {
+			return nil
+		}
+		return j.jwePublicKey
+	}, func() (err error) {
+		e := j.Encryption
+		if hasKeySource(e.PublicKeyEnv, e.PublicKeyFile) {
+			j.jwePublicKey, err = loadPublicKey(e.PublicKeyEnv, e.PublicKeyFile)
+			return
+		}
+		// 沒有設定公鑰時由私鑰推導
+		if j.jwePrivateKey == nil {
+			if j.jwePrivateKey, err = loadPrivateKey(e.PrivateKeyEnv, e.PrivateKeyFile); err != nil {
+				return
+			}
+		}
+		j.jwePublicKey = j.jwePrivateKey.Public()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k.(crypto.PublicKey), nil
+}
+
+func (j *JwtConf) jweKid(pub crypto.PublicKey) (string, error) {
+	if j.Encryption.Kid != "" {
+		return j.Encryption.Kid, nil
+	}
+	return JwkThumbprint(pub)
+}
+
+func (j *JwtConf) getJwePrivateKey() (crypto.Signer, error) {
+	k, err := j.lazyKey(func() interface{} {
+		if j.jwePrivateKey == nil {
+			return nil
+		}
+		return j.jwePrivateKey
+	}, func() (err error) {
+		j.jwePrivateKey, err = loadPrivateKey(j.Encryption.PrivateKeyEnv, j.Encryption.PrivateKeyFile)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k.(crypto.Signer), nil
+}
+
+func oaepHash(alg string) hash.Hash {
+	if alg == JweAlgRsaOaep256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+func ecdhCurve(c elliptic.Curve) (ecdh.Curve, error) {
+	switch c {
+	case elliptic.P256():
+		return ecdh.P256(), nil
+	case elliptic.P384():
+		return ecdh.P384(), nil
+	case elliptic.P521():
+		return ecdh.P521(), nil
+	}
+	return nil, errors.New("unsupported curve")
+}
+
+// RFC 7518 4.6.2, 只需要一輪 SHA-256 即可產生 256 bits
+func concatKDF(z []byte, algId string) []byte {
+	h := sha256.New()
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, 1)
+	h.Write(buf)
+	h.Write(z)
+	binary.BigEndian.PutUint32(buf, uint32(len(algId)))
+	h.Write(buf)
+	h.Write([]byte(algId))
+	binary.BigEndian.PutUint32(buf, 0)
+	h.Write(buf) // PartyUInfo
+	h.Write(buf) // PartyVInfo
+	binary.BigEndian.PutUint32(buf, 256)
+	h.Write(buf)
+	return h.Sum(nil)
+}
+
+func (j *JwtConf) encryptToken(signed string) (string, error) {
+	pub, err := j.getJwePublicKey()
+	if err != nil {
+		return "", err
+	}
+	kid, err := j.jweKid(pub)
+	if err != nil {
+		return "", err
+	}
+	header := &jweHeader{Alg: j.Encryption.Alg, Enc: jweEnc, Cty: "JWT", Kid: kid}
+	var cek, encryptedKey []byte
+	switch j.Encryption.Alg {
+	case JweAlgRsaOaep, JweAlgRsaOaep256:
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return "", errors.New("encryption key is not rsa")
+		}
+		cek = make([]byte, 32)
+		if _, err = rand.Read(cek); err != nil {
+			return "", err
+		}
+		encryptedKey, err = rsa.EncryptOAEP(oaepHash(j.Encryption.Alg), rand.Reader, rsaPub, cek, nil)
+		if err != nil {
+			return "", err
+		}
+	case JweAlgEcdhEs:
+		ecPub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return "", errors.New("encryption key is not ec")
+		}
+		curve, err := ecdhCurve(ecPub.Curve)
+		if err != nil {
+			return "", err
+		}
+		recipient, err := ecPub.ECDH()
+		if err != nil {
+			return "", err
+		}
+		eph, err := curve.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		z, err := eph.ECDH(recipient)
+		if err != nil {
+			return "", err
+		}
+		cek = concatKDF(z, jweEnc)
+		// uncompressed point: 0x04 || X || Y
+		point := eph.PublicKey().Bytes()
+		size := (len(point) - 1) / 2
+		header.Epk = &jweEpk{
+			Kty: "EC",
+			Crv: ecPub.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+			Y:   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+		}
+	default:
+		return "", errors.New("unsupported jwe alg: " + j.Encryption.Alg)
+	}
+
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(headerJson)
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(signed), []byte(protected))
+	tagPos := len(sealed) - gcm.Overhead()
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(sealed[:tagPos]),
+		base64.RawURLEncoding.EncodeToString(sealed[tagPos:]),
+	}, "."), nil
+}
+
+func (j *JwtConf) decryptToken(tokenStr string) (string, error) {
+	if !j.isJweEnabled() {
+		return "", errors.New("jwe not enabled")
+	}
+	parts := strings.Split(tokenStr, ".")
+	if len(parts) != 5 {
+		return "", errors.New("invalid jwe")
+	}
+	raw := make([][]byte, 5)
+	for i, p := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(p)
+		if err != nil {
+			return "", errors.Wrap(err, "invalid jwe")
+		}
+		raw[i] = b
+	}
+	header := &jweHeader{}
+	if err := json.Unmarshal(raw[0], header); err != nil {
+		return "", err
+	}
+	// 只接受設定的演算法, 避免被降級
+	if header.Alg != j.Encryption.Alg || header.Enc != jweEnc {
+		return "", errors.New("unexpected jwe alg")
+	}
+	if header.Kid != "" {
+		pub, err := j.getJwePublicKey()
+		if err != nil {
+			return "", err
+		}
+		if kid, err := j.jweKid(pub); err != nil || header.Kid != kid {
+			return "", errors.New("unknown jwe kid")
+		}
+	}
+	priv, err := j.getJwePrivateKey()
+	if err != nil {
+		return "", err
+	}
+	var cek []byte
+	switch header.Alg {
+	case JweAlgRsaOaep, JweAlgRsaOaep256:
+		rsaPriv, ok := priv.(*rsa.PrivateKey)
+		if !ok {
+			return "", errors.New("decryption key is not rsa")
+		}
+		cek, err = rsa.DecryptOAEP(oaepHash(header.Alg), nil, rsaPriv, raw[1], nil)
+		if err != nil {
+			return "", err
+		}
+	case JweAlgEcdhEs:
+		ecPriv, ok := priv.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", errors.New("decryption key is not ec")
+		}
+		if header.Epk == nil || header.Epk.Crv != ecPriv.Curve.Params().Name {
+			return "", errors.New("invalid epk")
+		}
+		x, err := b64BigInt(header.Epk.X)
+		if err != nil {
+			return "", err
+		}
+		y, err := b64BigInt(header.Epk.Y)
+		if err != nil {
+			return "", err
+		}
+		epk, err := (&ecdsa.PublicKey{Curve: ecPriv.Curve, X: x, Y: y}).ECDH()
+		if err != nil {
+			return "", err
+		}
+		recipient, err := ecPriv.ECDH()
+		if err != nil {
+			return "", err
+		}
+		z, err := recipient.ECDH(epk)
+		if err != nil {
+			return "", err
+		}
+		cek = concatKDF(z, jweEnc)
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(raw[2]) != gcm.NonceSize() {
+		return "", errors.New("invalid jwe iv")
+	}
+	plain, err := gcm.Open(nil, raw[2], append(raw[3], raw[4]...), []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
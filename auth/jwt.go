@@ -3,14 +3,18 @@ package auth
 import (
 	"bytes"
 	"compress/zlib"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha1"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -21,6 +25,8 @@ import (
 type JwtToken interface {
 	GetToken(host string, data map[string]interface{}, exp uint8) (*string, error)
 	GetTokenWithRefresh(host string, data map[string]interface{}, exp uint8) (*token, error)
+	// 建立 session 並將 refresh token 綁定到該 session, session 撤銷後即無法 refresh
+	GetTokenWithSession(host string, data map[string]interface{}, exp uint8, sess *Session) (*token, error)
 	ParseToken(tokenStr string) (*jwt.Token, error)
 	ParseTokenUnValidate(tokenStr string) (*jwt.Token, error)
 	// 對特定資源存取金鑰
@@ -36,52 +42,198 @@ type JwtDI interface {
 type JwtConf struct {
 	PrivateKeyFile string `yaml:"privatekey"`
 	PublicKeyFile  string `yaml:"publickey"`
+	// 環境變數名稱, 有值時優先於檔案, 內容為 PEM 或 base64 編碼的 PEM
+	PrivateKeyEnv string `yaml:"privatekey_env"`
+	PublicKeyEnv  string `yaml:"publickey_env"`
+	// WatchKeys 檢查金鑰檔案的間隔
+	ReloadInterval time.Duration `yaml:"reload_interval"`
 	Header         struct {
 		Kid string `yaml:"kid"`
+		Typ string `yaml:"typ"`
+		Cty string `yaml:"cty"`
+		X5t string `yaml:"x5t"`
+		// 其他自訂欄位, 不可覆寫 alg 與 usa
+		Extra map[string]interface{} `yaml:"extra"`
 	} `yaml:"header"`
 	Claims struct {
 		ExpDuration time.Duration `yaml:"exp"`
 	} `yaml:"claims"`
-	RefreshSecret string `yaml:"refresh_secret"`
+	RefreshSecret string  `yaml:"refresh_secret"`
+	Encryption    JweConf `yaml:"encryption"`
+
+	keyMu         sync.RWMutex
+	publicKey     crypto.PublicKey
+	privateKey    crypto.Signer
+	jwePublicKey  crypto.PublicKey
+	jwePrivateKey crypto.Signer
+	sessionStore  SessionStore
+	listeners     AuthEventListeners
+}
 
-	myHeader   map[string]interface{}
-	publicKey  *rsa.PublicKey
-	privateKey *rsa.PrivateKey
+func (j *JwtConf) SetSessionStore(store SessionStore) {
+	j.sessionStore = store
 }
 
-func (j *JwtConf) getHeader() map[string]interface{} {
-	if j.myHeader != nil {
-		delete(j.myHeader, "usa")
-		return j.myHeader
-	}
-	j.myHeader = map[string]interface{}{
+// 每次簽章都建立新的 header, 避免並行時互相影響
+func (j *JwtConf) newHeader(usage string) map[string]interface{} {
+	header := map[string]interface{}{
 		"kid": j.Header.Kid,
 	}
-	return j.myHeader
+	for k, v := range j.Header.Extra {
+		if k == "alg" || k == HeaderUsage {
+			continue
+		}
+		header[k] = v
+	}
+	for k, v := range map[string]string{
+		"typ": j.Header.Typ,
+		"cty": j.Header.Cty,
+		"x5t": j.Header.X5t,
+	} {
+		if v != "" {
+			header[k] = v
+		}
+	}
+	if usage != "" {
+		header[HeaderUsage] = usage
+	}
+	return header
+}
+
+// 以 PEM 內容建立 JwtConf, 不需要讀檔; privatePEM 為空時只能驗證 token
+func NewJwtConfFromPEM(kid string, privatePEM, publicPEM []byte) (*JwtConf, error) {
+	j := &JwtConf{}
+	j.Header.Kid = kid
+	var err error
+	if len(privatePEM) > 0 {
+		if j.privateKey, err = parsePrivateKeyPEM(privatePEM); err != nil {
+			return nil, err
+		}
+	}
+	if len(publicPEM) > 0 {
+		if j.publicKey, err = parsePublicKeyPEM(publicPEM); err != nil {
+			return nil, err
+		}
+	}
+	if j.privateKey == nil && j.publicKey == nil {
+		return nil, errors.New("no key")
+	}
+	if _, err = j.getSigningMethod(); err != nil {
+		return nil, err
+	}
+	return j, nil
 }
 
-func (j *JwtConf) getPublicKey() (*rsa.PublicKey, error) {
-	if j.publicKey != nil {
-		return j.publicKey, nil
+// 以 crypto.Signer 建立 JwtConf, 公鑰取自 signer.Public()
+func NewJwtConfWithSigner(kid string, signer crypto.Signer) (*JwtConf, error) {
+	if signer == nil {
+		return nil, errors.New("signer is nil")
 	}
-	publicData, err := os.ReadFile(j.PublicKeyFile)
+	j := &JwtConf{privateKey: signer}
+	j.Header.Kid = kid
+	if _, err := j.getSigningMethod(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// 產生臨時 ECDSA P-256 金鑰與 refresh secret, 用於測試與本機開發
+// kid 為空時使用 JWK thumbprint
+func NewEphemeralJwtConf(kid string) (*JwtConf, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return nil, err
 	}
-	j.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(publicData)
-	return j.publicKey, err
+	if kid == "" {
+		if kid, err = JwkThumbprint(key.Public()); err != nil {
+			return nil, err
+		}
+	}
+	j, err := NewJwtConfWithSigner(kid, key)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, err
+	}
+	j.RefreshSecret = hex.EncodeToString(secret)
+	return j, nil
 }
 
-func (j *JwtConf) getPrivateKey() (*rsa.PrivateKey, error) {
-	if j.privateKey != nil {
-		return j.privateKey, nil
+func (j *JwtConf) getPublicKey() (crypto.PublicKey, error) {
+	k, err := j.lazyKey(func() interface{} {
+		if j.publicKey == nil {
+			return nil
+		}
+		return j.publicKey
+	}, func() (err error) {
+		if hasKeySource(j.PublicKeyEnv, j.PublicKeyFile) {
+			j.publicKey, err = loadPublicKey(j.PublicKeyEnv, j.PublicKeyFile)
+			return
+		}
+		// 沒有設定公鑰時由私鑰推導
+		if j.privateKey == nil {
+			if j.privateKey, err = loadPrivateKey(j.PrivateKeyEnv, j.PrivateKeyFile); err != nil {
+				return
+			}
+		}
+		j.publicKey = j.privateKey.Public()
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	privateData, err := os.ReadFile(j.PrivateKeyFile)
+	return k.(crypto.PublicKey), nil
+}
+
+func (j *JwtConf) getPrivateKey() (crypto.Signer, error) {
+	k, err := j.lazyKey(func() interface{} {
+		if j.privateKey == nil {
+			return nil
+		}
+		return j.privateKey
+	}, func() (err error) {
+		j.privateKey, err = loadPrivateKey(j.PrivateKeyEnv, j.PrivateKeyFile)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
-	j.privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(privateData)
-	return j.privateKey, err
+	return k.(crypto.Signer), nil
+}
+
+func (j *JwtConf) getSigningMethod() (*signerMethod, error) {
+	pk, err := j.getPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return signingMethodFor(pk)
+}
+
+// 依金鑰類型回傳簽章演算法: RSA 為 RS256, ECDSA 依曲線為 ES256/ES384/ES512, Ed25519 為 EdDSA
+func (j *JwtConf) SigningAlg() (string, error) {
+	m, err := j.getSigningMethod()
+	if err != nil {
+		return "", err
+	}
+	return m.Alg(), nil
+}
+
+// 只接受與金鑰類型相符的 alg, 避免以其他演算法驗證
+func (j *JwtConf) keyFunc(token *jwt.Token) (interface{}, error) {
+	pk, err := j.getPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	m, err := signingMethodFor(pk)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != m.Alg() {
+		return nil, errors.Errorf("unexpected signing method: %s", token.Method.Alg())
+	}
+	return pk, nil
 }
 
 func (j *JwtConf) GetKid() string {
@@ -96,18 +248,22 @@ func (j *JwtConf) ParseTokenUnValidate(tokenStr string) (*jwt.Token, error) {
 	if j == nil {
 		return nil, errors.New("jwtConf is nil")
 	}
+	tokenStr, err := j.decryptIfNeeded(tokenStr)
+	if err != nil {
+		return nil, err
+	}
 	parser := jwt.Parser{
 		SkipClaimsValidation: true,
 	}
 
-	token, err := parser.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		pk, err := j.getPublicKey()
-		return pk, err
-	})
+	token, err := parseJwt(&parser, tokenStr, j.keyFunc)
 	if token == nil {
 		return nil, errors.New("token is nil")
 	}
 	if token.Valid {
+		if isOneTimeToken(token) {
+			return nil, errors.New("one-time token is not allowed")
+		}
 		return token, nil
 	} else if ve, ok := err.(*jwt.ValidationError); ok {
 		if ve.Errors&jwt.ValidationErrorMalformed != 0 {
@@ -125,14 +281,18 @@ func (j *JwtConf) ParseToken(tokenStr string) (*jwt.Token, error) {
 	if j == nil {
 		return nil, errors.New("jwtConf is nil")
 	}
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		pk, err := j.getPublicKey()
-		return pk, err
-	})
+	tokenStr, err := j.decryptIfNeeded(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	token, err := parseJwt(&jwt.Parser{}, tokenStr, j.keyFunc)
 	if token == nil {
 		return nil, errors.New("token is nil")
 	}
 	if token.Valid {
+		if isOneTimeToken(token) {
+			return nil, errors.New("one-time token is not allowed")
+		}
 		return token, nil
 	} else if ve, ok := err.(*jwt.ValidationError); ok {
 		if ve.Errors&jwt.ValidationErrorMalformed != 0 {
@@ -151,64 +311,134 @@ func (j *JwtConf) GetToken(host string, data map[string]interface{}, exp uint8)
 	if j == nil {
 		return nil, errors.New("jwtConf not set")
 	}
-	if data == nil {
-		return nil, errors.New("no data")
+	return issueToken(j, j.listeners, host, data, exp)
+}
+
+func (j *JwtConf) sealAccess(claims map[string]interface{}) (string, error) {
+	ss, err := j.signClaims(jwt.MapClaims(claims))
+	if err != nil {
+		return "", err
 	}
-	if exp <= 0 {
-		exp = 60
-	} else if exp > 180 {
-		exp = 180
+	return j.encryptIfNeeded(ss)
+}
+
+func (j *JwtConf) encryptIfNeeded(signed string) (string, error) {
+	if !j.isJweEnabled() {
+		return signed, nil
 	}
+	return j.encryptToken(signed)
+}
 
-	now := time.Now()
-	data["iss"] = host
-	data["iat"] = now.Unix()
-	if exp > 0 {
-		data["exp"] = now.Add(time.Duration(exp) * time.Minute).Unix()
+// 解開 JWE 取得內層簽章 token, 不是 JWE 則原樣回傳
+func (j *JwtConf) DecryptToken(tokenStr string) (string, error) {
+	if !isJwe(tokenStr) {
+		return tokenStr, nil
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims(data))
+	return j.decryptToken(tokenStr)
+}
 
-	for k, v := range j.getHeader() {
-		token.Header[k] = v
+// 啟用加密時不接受未加密的 token
+func (j *JwtConf) decryptIfNeeded(tokenStr string) (string, error) {
+	if !isJwe(tokenStr) {
+		if j.isJweEnabled() {
+			return "", errors.New("unencrypted token not accepted")
+		}
+		return tokenStr, nil
 	}
+	return j.decryptToken(tokenStr)
+}
+
+func (j *JwtConf) signClaims(claims jwt.MapClaims) (string, error) {
+	return j.signWithHeader(claims, j.newHeader(""))
+}
 
+func (j *JwtConf) signWithHeader(claims jwt.MapClaims, header map[string]interface{}) (string, error) {
 	pk, err := j.getPrivateKey()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	ss, err := token.SignedString(pk)
+	m, err := signingMethodFor(pk.Public())
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return &ss, nil
+	token := jwt.NewWithClaims(m, claims)
+	for k, v := range header {
+		token.Header[k] = v
+	}
+	return token.SignedString(pk)
 }
 
 func (j *JwtConf) GetTokenWithRefresh(host string, data map[string]interface{}, exp uint8) (*token, error) {
-	if j.RefreshSecret == "" {
-		return nil, errors.New("refresh secret not set")
-	}
-
-	t, err := j.GetToken(host, data, exp)
-	if err != nil {
-		return nil, err
+	if j == nil {
+		return nil, errors.New("jwtConf not set")
 	}
+	return issueTokenWithRefresh(j, j.listeners, host, data, exp)
+}
 
-	refreshToken, err := j.createRefreshToken(host, data)
-	if err != nil {
-		return nil, err
-	}
-	return &token{AccessToken: *t, RefreshToken: refreshToken}, nil
+func (j *JwtConf) GetTokenWithSession(host string, data map[string]interface{}, exp uint8, sess *Session) (*token, error) {
+	return issueWithSession(j.sessionStore, sess, data, func(claims map[string]interface{}) (*token, error) {
+		return j.GetTokenWithRefresh(host, claims, exp)
+	})
 }
 
 func (j *JwtConf) RefreshAccessToken(refreshToken string) (*string, error) {
 	if j == nil {
 		return nil, errors.New("jwtConf not set")
 	}
-	host, data, err := j.pareserRefreshToken(refreshToken)
+	return refreshAccessToken(j, j.sessionStore, j.listeners, refreshToken)
+}
+
+func (j *JwtConf) AddEventListener(listeners ...AuthEventListener) {
+	j.listeners = append(j.listeners, listeners...)
+}
+
+// reason 為固定的錯誤 (作為 metrics label), cause 為實際原因, 只記錄在 Detail
+func emitTokenEvent(listeners AuthEventListeners, typ AuthEventType, data map[string]interface{}, reason error, cause error) {
+	if len(listeners) == 0 {
+		return
+	}
+	e := NewAuthEvent(typ, nil)
+	e.UserId = claimString(data, ClaimUserId)
+	e.Account = claimString(data, ClaimAccount)
+	e.setActor(parseActor(data[ClaimActor]))
+	if reason != nil {
+		e.Reason = reason.Error()
+	}
+	if cause != nil {
+		e.Detail = cause.Error()
+	}
+	listeners.OnAuthEvent(e)
+}
+
+// 對資源簽發的 access token 沒有使用者, 以 source 與 sourceId 記錄
+func emitAccessTokenEvent(listeners AuthEventListeners, source string, id interface{}) {
+	if len(listeners) == 0 {
+		return
+	}
+	e := NewAuthEvent(EventTokenIssued, nil)
+	e.UserId = fmt.Sprint(id)
+	e.Account = source
+	e.Reason = UsageAccess
+	listeners.OnAuthEvent(e)
+}
+
+// refresh 時確認 sid 對應的 session 仍有效
+func checkTokenSession(store SessionStore, data map[string]interface{}) error {
+	sid, ok := data[ClaimSessionId].(string)
+	if !ok {
+		return nil
+	}
+	if store == nil {
+		return errors.New("session store not set")
+	}
+	sess, err := store.Get(sid)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if sess.Revoked {
+		return errors.New("session revoked")
 	}
-	return j.GetToken(host, data, 60)
+	return store.Touch(sid, "", time.Now())
 }
 
 func (j *JwtConf) GetAccessToken(host string, source string, id interface{}, db string, perm ApiPerm) (*string, error) {
@@ -216,59 +446,69 @@ func (j *JwtConf) GetAccessToken(host string, source string, id interface{}, db
 		return nil, errors.New("jwtConf not set")
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims(map[string]interface{}{
+	ss, err := j.signWithHeader(jwt.MapClaims{
 		"iss":      host,
 		"source":   source,
 		"sourceId": id,
 		"db":       db,
 		"per":      perm,
-	}))
-
-	token.Header = j.getHeader()
-	token.Header["usa"] = "access"
-
-	pk, err := j.getPrivateKey()
+	}, j.newHeader(UsageAccess))
 	if err != nil {
 		return nil, err
 	}
-	ss, err := token.SignedString(pk)
-	if err != nil {
+	if ss, err = j.encryptIfNeeded(ss); err != nil {
 		return nil, err
 	}
+	emitAccessTokenEvent(j.listeners, source, id)
 	return &ss, nil
 }
 
 type token struct {
 	AccessToken  string
 	RefreshToken string
+	SessionId    string `json:",omitempty"`
+}
+
+func (j *JwtConf) ParseRefreshToken(refreshToken string) (host string, data map[string]any, err error) {
+	return parseRefreshToken(j, refreshToken)
+}
+
+func (j *JwtConf) refreshReady() error {
+	if j.RefreshSecret == "" {
+		return errors.New("refresh secret not set")
+	}
+	return nil
 }
 
-func (j *JwtConf) pareserRefreshToken(refreshToken string) (host string, data map[string]any, err error) {
+func (j *JwtConf) openRefresh(refreshToken string) (map[string]interface{}, error) {
 	sha1 := sha1.New()
 	io.WriteString(sha1, j.RefreshSecret)
 
 	salt := string(sha1.Sum(nil))[0:16]
 	block, err := aes.NewCipher([]byte(salt))
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	decodeData, err := base64.URLEncoding.DecodeString(refreshToken)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
+	if len(decodeData) < nonceSize {
+		return nil, errors.New("invalid refresh token")
+	}
 	nonce, ciphertext := decodeData[:nonceSize], decodeData[nonceSize:]
 
 	compressData, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	b := bytes.NewReader(compressData)
@@ -280,25 +520,14 @@ func (j *JwtConf) pareserRefreshToken(refreshToken string) (host string, data ma
 		return []byte(j.RefreshSecret), nil
 	})
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
-	data = jwtToken.Claims.(jwt.MapClaims)
-	host = data["iss"].(string)
-	delete(data, "iss")
-	delete(data, "iat")
-	delete(data, "exp")
-	return
-
+	return jwtToken.Claims.(jwt.MapClaims), nil
 }
 
-func (j *JwtConf) createRefreshToken(host string, data map[string]any) (string, error) {
-	now := time.Now()
-	data["iss"] = host
-	data["iat"] = now.Unix()
-	data["exp"] = now.AddDate(0, 0, 1).Unix()
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(data))
-	for k, v := range j.getHeader() {
+func (j *JwtConf) sealRefresh(claims map[string]interface{}) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims))
+	for k, v := range j.newHeader("") {
 		token.Header[k] = v
 	}
 	refreshToken, err := token.SignedString([]byte(j.RefreshSecret))
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func newFileJwtConf(t *testing.T) *JwtConf {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "jwt.pem")
+	writeECKey(t, keyFile)
+	j := &JwtConf{PrivateKeyFile: keyFile, RefreshSecret: "refresh-secret"}
+	j.Header.Kid = "kid"
+	j.Header.Typ = "JWT"
+	j.Header.Extra = map[string]interface{}{"env": "test", HeaderUsage: "forged"}
+	return j
+}
+
+func tokenUsage(t *testing.T, tokenStr string) (string, bool) {
+	t.Helper()
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenStr, jwt.MapClaims{})
+	if err != nil {
+		t.Error(err)
+		return "", false
+	}
+	usage, ok := token.Header[HeaderUsage].(string)
+	return usage, ok
+}
+
+// 以 go test -race 執行, 確認並行簽發時 usa 不會出現在一般 token
+func TestJwtConfConcurrentHeaders(t *testing.T) {
+	j := newFileJwtConf(t)
+	const workers, rounds = 8, 20
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(5)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				tokenStr, err := j.GetToken("host", map[string]interface{}{ClaimUserId: "u1"}, 10)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if usage, ok := tokenUsage(t, *tokenStr); ok {
+					t.Errorf("GetToken header has usa %q", usage)
+				}
+				if _, err = j.ParseToken(*tokenStr); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				tokenStr, err := j.GetAccessToken("host", "svc", "id", "db", "read")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if usage, _ := tokenUsage(t, *tokenStr); usage != UsageAccess {
+					t.Errorf("GetAccessToken usa = %q", usage)
+				}
+				token, err := j.ParseToken(*tokenStr)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if u := NewReqUserFromToken(token); u.GetUsage() != UsageAccess {
+					t.Error("access token user has no access usage")
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				tok, err := j.GetTokenWithRefresh("host", map[string]interface{}{ClaimUserId: "u1"}, 10)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if usage, ok := tokenUsage(t, tok.AccessToken); ok {
+					t.Errorf("GetTokenWithRefresh header has usa %q", usage)
+				}
+				newToken, err := j.RefreshAccessToken(tok.RefreshToken)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if usage, ok := tokenUsage(t, *newToken); ok {
+					t.Errorf("refreshed token header has usa %q", usage)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if _, err := j.SigningAlg(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if err := j.ReloadKeys(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestJweRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{JweAlgRsaOaep, rsaKey},
+		{JweAlgRsaOaep256, rsaKey},
+		{JweAlgEcdhEs, ecKey},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			j, err := NewEphemeralJwtConf("kid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			j.Encryption.Alg = tc.alg
+			j.jwePrivateKey = tc.key
+			j.jwePublicKey = tc.key.Public()
+
+			tokenStr, err := j.GetToken("host", map[string]interface{}{ClaimUserId: "u1", "secret": "s"}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isJwe(*tokenStr) {
+				t.Fatalf("token is not a JWE: %s", *tokenStr)
+			}
+			token, err := j.ParseToken(*tokenStr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims := token.Claims.(jwt.MapClaims); claims["secret"] != "s" || claims[ClaimUserId] != "u1" {
+				t.Fatalf("claims %v", claims)
+			}
+
+			tampered := []byte(*tokenStr)
+			tampered[len(tampered)-2] ^= 1
+			if _, err = j.ParseToken(string(tampered)); err == nil {
+				t.Fatal("tampered JWE accepted")
+			}
+
+			wantKid, _ := JwkThumbprint(tc.key.Public())
+			if kid := jweHeaderKid(t, *tokenStr); kid != wantKid {
+				t.Fatalf("jwe kid %q, want the encryption key thumbprint %q", kid, wantKid)
+			}
+			signed, err := j.DecryptToken(*tokenStr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = j.ParseToken(signed); err == nil {
+				t.Fatal("bare JWS accepted while encryption is enabled")
+			}
+			j.Encryption.Kid = "enc-1"
+			if _, err = j.ParseToken(*tokenStr); err == nil {
+				t.Fatal("JWE for another encryption kid accepted")
+			}
+		})
+	}
+}
+
+// apitool keygen -type ed25519 產生的 PKCS8 金鑰簽發 EdDSA token
+func TestJwtEdDSA(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "jwt.key")
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	j := &JwtConf{PrivateKeyFile: keyFile}
+	if alg, err := j.SigningAlg(); err != nil || alg != "EdDSA" {
+		t.Fatalf("alg %q, err %v", alg, err)
+	}
+	tokenStr, err := j.GetToken("host", map[string]interface{}{ClaimUserId: "u1"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := j.ParseToken(*tokenStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Method.Alg() != "EdDSA" || token.Claims.(jwt.MapClaims)[ClaimUserId] != "u1" {
+		t.Fatalf("token %+v", token)
+	}
+
+	tampered := []byte(*tokenStr)
+	tampered[len(tampered)-2] ^= 1
+	if _, err = j.ParseToken(string(tampered)); err == nil {
+		t.Fatal("tampered EdDSA token accepted")
+	}
+	if _, err = JwkThumbprint(key.Public()); err != nil {
+		t.Fatal(err)
+	}
+
+	// EdDSA 由本套件自行對應, 不可註冊到 jwt-go 的全域表
+	if m := jwt.GetSigningMethod("EdDSA"); m != nil {
+		t.Fatalf("EdDSA registered globally: %T", m)
+	}
+	expired, err := j.signClaims(jwt.MapClaims{ClaimUserId: "u1", "exp": time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = j.ParseToken(expired); err == nil {
+		t.Fatal("expired EdDSA token accepted")
+	}
+	if _, err = j.ParseTokenUnValidate(expired); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func jweHeaderKid(t *testing.T, tokenStr string) string {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(strings.SplitN(tokenStr, ".", 2)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := &jweHeader{}
+	if err = json.Unmarshal(b, header); err != nil {
+		t.Fatal(err)
+	}
+	return header.Kid
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+	if pub, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, errors.New("unsupported public key")
+}
+
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key")
+}
+
+// 以 crypto.Signer 簽章, 可接記憶體中的私鑰或 KMS/HSM 等外部簽章器
+// 驗證仍交給 jwt-go 內建的 RS/ES method, jwt-go 沒有的 EdDSA 自行驗證
+type signerMethod struct {
+	alg     string
+	hash    crypto.Hash
+	keySize int
+}
+
+var (
+	signerRS256 = &signerMethod{alg: "RS256", hash: crypto.SHA256}
+	signerES256 = &signerMethod{alg: "ES256", hash: crypto.SHA256, keySize: 32}
+	signerES384 = &signerMethod{alg: "ES384", hash: crypto.SHA384, keySize: 48}
+	signerES512 = &signerMethod{alg: "ES512", hash: crypto.SHA512, keySize: 66}
+	// Ed25519 直接簽原文, 不先做 hash
+	signerEdDSA = &signerMethod{alg: "EdDSA"}
+)
+
+// 與 jwt.Parser.Parse 相同的流程, 但 EdDSA 在此直接對應 signerEdDSA,
+// 不註冊到 jwt-go 的全域 signing method 表, 以免影響同一程式中其他使用 jwt-go 的套件
+func parseJwt(parser *jwt.Parser, tokenStr string, keyFunc jwt.Keyfunc) (*jwt.Token, error) {
+	token, parts, err := parser.ParseUnverified(tokenStr, jwt.MapClaims{})
+	if token != nil && token.Header["alg"] == signerEdDSA.alg {
+		token.Method = signerEdDSA
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors == jwt.ValidationErrorUnverifiable {
+			err = nil
+		}
+	}
+	if err != nil {
+		return token, err
+	}
+
+	key, err := keyFunc(token)
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok {
+			return token, ve
+		}
+		return token, &jwt.ValidationError{Inner: err, Errors: jwt.ValidationErrorUnverifiable}
+	}
+	vErr := &jwt.ValidationError{}
+	if !parser.SkipClaimsValidation {
+		if err = token.Claims.Valid(); err != nil {
+			if ve, ok := err.(*jwt.ValidationError); ok {
+				vErr = ve
+			} else {
+				vErr = &jwt.ValidationError{Inner: err, Errors: jwt.ValidationErrorClaimsInvalid}
+			}
+		}
+	}
+	token.Signature = parts[2]
+	if err = token.Method.Verify(strings.Join(parts[:2], "."), token.Signature, key); err != nil {
+		vErr.Inner = err
+		vErr.Errors |= jwt.ValidationErrorSignatureInvalid
+	}
+	if vErr.Errors == 0 {
+		token.Valid = true
+		return token, nil
+	}
+	return token, vErr
+}
+
+func signingMethodFor(pub crypto.PublicKey) (*signerMethod, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return signerRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return signerES256, nil
+		case 384:
+			return signerES384, nil
+		case 521:
+			return signerES512, nil
+		}
+	case ed25519.PublicKey:
+		return signerEdDSA, nil
+	}
+	return nil, errors.Errorf("unsupported key type: %T", pub)
+}
+
+func (m *signerMethod) Alg() string {
+	return m.alg
+}
+
+func (m *signerMethod) Verify(signingString, signature string, key interface{}) error {
+	if m == signerEdDSA {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return jwt.ErrInvalidKeyType
+		}
+		sig, err := jwt.DecodeSegment(signature)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(pub, []byte(signingString), sig) {
+			return jwt.ErrSignatureInvalid
+		}
+		return nil
+	}
+	return jwt.GetSigningMethod(m.alg).Verify(signingString, signature, key)
+}
+
+func (m *signerMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	digest := []byte(signingString)
+	if m.hash != 0 {
+		h := m.hash.New()
+		h.Write(digest)
+		digest = h.Sum(nil)
+	}
+	sig, err := signer.Sign(rand.Reader, digest, m.hash)
+	if err != nil {
+		return "", err
+	}
+	if m.keySize > 0 {
+		// crypto.Signer 回傳 ASN.1 DER, JWS 需要固定長度的 r||s
+		var rs struct{ R, S *big.Int }
+		if _, err = asn1.Unmarshal(sig, &rs); err != nil {
+			return "", err
+		}
+		out := make([]byte, 2*m.keySize)
+		rs.R.FillBytes(out[:m.keySize])
+		rs.S.FillBytes(out[m.keySize:])
+		sig = out
+	}
+	return jwt.EncodeSegment(sig), nil
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultReloadInterval = 30 * time.Second
+
+var errNoKeySource = errors.New("key source not set")
+
+// 環境變數有值時優先使用, 否則讀檔
+func readKeyPEM(env, file string) ([]byte, error) {
+	if env != "" {
+		if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+			return decodeKeyEnv(v)
+		}
+	}
+	if file == "" {
+		return nil, errNoKeySource
+	}
+	return os.ReadFile(file)
+}
+
+// 環境變數可為 PEM (換行可寫成 \n) 或 base64 編碼的 PEM
+func decodeKeyEnv(v string) ([]byte, error) {
+	if strings.HasPrefix(v, "-----BEGIN") {
+		return []byte(strings.ReplaceAll(v, `\n`, "\n")), nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding} {
+		if b, err := enc.DecodeString(v); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New("key env is neither pem nor base64")
+}
+
+func hasKeySource(env, file string) bool {
+	return file != "" || (env != "" && os.Getenv(env) != "")
+}
+
+func loadPublicKey(env, file string) (crypto.PublicKey, error) {
+	data, err := readKeyPEM(env, file)
+	if err != nil {
+		return nil, err
+	}
+	return parsePublicKeyPEM(data)
+}
+
+func loadPrivateKey(env, file string) (crypto.Signer, error) {
+	data, err := readKeyPEM(env, file)
+	if err != nil {
+		return nil, err
+	}
+	return parsePrivateKeyPEM(data)
+}
+
+// 已載入則直接回傳, 否則持寫鎖載入; load 在鎖內執行, 不可再呼叫 getter
+func (j *JwtConf) lazyKey(get func() interface{}, load func() error) (interface{}, error) {
+	j.keyMu.RLock()
+	k := get()
+	j.keyMu.RUnlock()
+	if k != nil {
+		return k, nil
+	}
+	j.keyMu.Lock()
+	defer j.keyMu.Unlock()
+	if k = get(); k != nil {
+		return k, nil
+	}
+	if err := load(); err != nil {
+		return nil, err
+	}
+	return get(), nil
+}
+
+type keySet struct {
+	publicKey     crypto.PublicKey
+	privateKey    crypto.Signer
+	jwePublicKey  crypto.PublicKey
+	jwePrivateKey crypto.Signer
+}
+
+// 重新讀取所有設定了來源的金鑰, 全部成功才整組替換, 失敗時保留舊金鑰
+// 以建構函式直接給定的金鑰沒有來源, 不受影響
+func (j *JwtConf) ReloadKeys() error {
+	var ks keySet
+	var err error
+	if hasKeySource(j.PrivateKeyEnv, j.PrivateKeyFile) {
+		if ks.privateKey, err = loadPrivateKey(j.PrivateKeyEnv, j.PrivateKeyFile); err != nil {
+			return errors.Wrap(err, "load private key")
+		}
+	}
+	if hasKeySource(j.PublicKeyEnv, j.PublicKeyFile) {
+		if ks.publicKey, err = loadPublicKey(j.PublicKeyEnv, j.PublicKeyFile); err != nil {
+			return errors.Wrap(err, "load public key")
+		}
+	}
+	if e := j.Encryption; hasKeySource(e.PrivateKeyEnv, e.PrivateKeyFile) {
+		if ks.jwePrivateKey, err = loadPrivateKey(e.PrivateKeyEnv, e.PrivateKeyFile); err != nil {
+			return errors.Wrap(err, "load jwe private key")
+		}
+	}
+	if e := j.Encryption; hasKeySource(e.PublicKeyEnv, e.PublicKeyFile) {
+		if ks.jwePublicKey, err = loadPublicKey(e.PublicKeyEnv, e.PublicKeyFile); err != nil {
+			return errors.Wrap(err, "load jwe public key")
+		}
+	}
+
+	j.keyMu.Lock()
+	defer j.keyMu.Unlock()
+	// 沒有公鑰來源時公鑰是由私鑰推導的, 私鑰替換時一起更新
+	if ks.privateKey != nil {
+		j.privateKey = ks.privateKey
+		if ks.publicKey == nil {
+			j.publicKey = ks.privateKey.Public()
+		}
+	}
+	if ks.publicKey != nil {
+		j.publicKey = ks.publicKey
+	}
+	if ks.jwePrivateKey != nil {
+		j.jwePrivateKey = ks.jwePrivateKey
+		if ks.jwePublicKey == nil {
+			j.jwePublicKey = ks.jwePrivateKey.Public()
+		}
+	}
+	if ks.jwePublicKey != nil {
+		j.jwePublicKey = ks.jwePublicKey
+	}
+	return nil
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func (j *JwtConf) keyFiles() []string {
+	var files []string
+	for _, f := range []string{
+		j.PrivateKeyFile, j.PublicKeyFile,
+		j.Encryption.PrivateKeyFile, j.Encryption.PublicKeyFile,
+	} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func statKeyFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, f := range files {
+		// Kubernetes secret 以替換 symlink 更新, os.Stat 會跟隨 symlink
+		if fi, err := os.Stat(f); err == nil {
+			stamps[f] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+		}
+	}
+	return stamps
+}
+
+func stampsChanged(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !w.modTime.Equal(v.modTime) || w.size != v.size {
+			return true
+		}
+	}
+	return false
+}
+
+// 依 ReloadInterval (預設 30 秒) 檢查金鑰檔案, 有變動即呼叫 ReloadKeys
+// 會阻塞到 ctx 結束, 一般以 go conf.WatchKeys(ctx, onError) 執行
+// 重新載入失敗 (例如檔案寫到一半) 會交給 onError, 下次檢查時重試
+func (j *JwtConf) WatchKeys(ctx context.Context, onError func(error)) {
+	interval := j.ReloadInterval
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+	files := j.keyFiles()
+	if len(files) == 0 {
+		return
+	}
+	last := statKeyFiles(files)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := statKeyFiles(files)
+		if !stampsChanged(last, now) {
+			continue
+		}
+		if err := j.ReloadKeys(); err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			continue
+		}
+		last = now
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeECKey(t *testing.T, path string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadKeysPrivateKeyOnly(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "jwt.pem")
+	jweFile := filepath.Join(dir, "jwe.pem")
+	writeECKey(t, keyFile)
+	writeECKey(t, jweFile)
+
+	j := &JwtConf{PrivateKeyFile: keyFile}
+	j.Encryption.Alg = JweAlgEcdhEs
+	j.Encryption.PrivateKeyFile = jweFile
+	issue := func() string {
+		t.Helper()
+		tokenStr, err := j.GetToken("host", map[string]interface{}{ClaimUserId: "u1"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *tokenStr
+	}
+	oldToken := issue()
+	if _, err := j.ParseToken(oldToken); err != nil {
+		t.Fatal(err)
+	}
+
+	writeECKey(t, keyFile)
+	writeECKey(t, jweFile)
+	if err := j.ReloadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.ParseToken(issue()); err != nil {
+		t.Fatalf("token signed after reload: %v", err)
+	}
+	if _, err := j.ParseToken(oldToken); err == nil {
+		t.Fatal("token of the replaced key still accepted")
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+const (
+	ClaimAmr      = "amr"
+	ClaimAuthTime = "auth_time"
+
+	AmrOtp = "otp"
+	AmrMfa = "mfa"
+)
+
+// 路由設定 MfaMaxAge 時, bearer middleware 由此取得 amr/auth_time
+// 上游 middleware 自行設定的 ReqUser 應實作此介面, 否則需以 WithTokenParser 從 token 讀取
+type MfaReqUser interface {
+	ReqUser
+	GetAmr() []string
+	GetAuthTime() time.Time
+}
+
+type OtpValidator interface {
+	ValidateCode(code string) (valid bool, err error)
+}
+
+// 驗證成功時將 amr/auth_time 寫入 data, 之後用 data 簽發的 token 即帶有 MFA 資訊
+func ValidateMfa(v OtpValidator, code string, data map[string]interface{}) (bool, error) {
+	valid, err := v.ValidateCode(code)
+	if err != nil || !valid {
+		return false, err
+	}
+	SetMfaClaims(data, time.Now(), AmrOtp)
+	return true, nil
+}
+
+func SetMfaClaims(data map[string]interface{}, authTime time.Time, methods ...string) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data[ClaimAmr] = methods
+	data[ClaimAuthTime] = authTime.Unix()
+	return data
+}
+
+func GetMfaFromClaims(claims map[string]interface{}) (amr []string, authTime time.Time) {
+	switch v := claims[ClaimAmr].(type) {
+	case []string:
+		amr = v
+	case []interface{}:
+		for _, m := range v {
+			if s, ok := m.(string); ok {
+				amr = append(amr, s)
+			}
+		}
+	}
+	if sec, ok := claimInt64(claims[ClaimAuthTime]); ok {
+		authTime = time.Unix(sec, 0)
+	}
+	return
+}
+
+func claimInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func NewMfaReqUser(u ReqUser, amr []string, authTime time.Time) MfaReqUser {
+	return &mfaReqUser{
+		ReqUser:  u,
+		amr:      amr,
+		authTime: authTime,
+	}
+}
+
+type mfaReqUser struct {
+	ReqUser
+	amr      []string
+	authTime time.Time
+}
+
+func (u *mfaReqUser) GetAmr() []string {
+	return u.amr
+}
+
+func (u *mfaReqUser) GetAuthTime() time.Time {
+	return u.authTime
+}
+
+func checkMfa(mu MfaReqUser, maxAge time.Duration) error {
+	if mu == nil {
+		return errors.Error_Auth_Mfa_Required
+	}
+	if !isStrInList(AmrOtp, mu.GetAmr()...) && !isStrInList(AmrMfa, mu.GetAmr()...) {
+		return errors.Error_Auth_Mfa_Required
+	}
+	authTime := mu.GetAuthTime()
+	if authTime.IsZero() {
+		return errors.Error_Auth_Mfa_Required
+	}
+	if time.Since(authTime) > maxAge {
+		return errors.Error_Auth_Mfa_Expired
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+func newMfaServer(t *testing.T, upstream gin.HandlerFunc, opts ...BearAuthOption) *gin.Engine {
+	t.Helper()
+	m := NewGinBearAuthMid(false, opts...)
+	m.AddAuthPath("/pay", http.MethodPost, true, nil)
+	m.(GinAuthRouteMidInter).SetRouteOpts("/pay", http.MethodPost, RouteAuthOpts{MfaMaxAge: 5 * time.Minute})
+	m.SetApiErrorHandler(func(c *gin.Context, err error) {
+		apiErr := err.(errors.ApiError)
+		c.AbortWithStatusJSON(apiErr.GetStatus(), gin.H{"errorKey": apiErr.GetKey()})
+	})
+	r := gin.New()
+	r.POST("/pay", upstream, m.Handler(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return r
+}
+
+func TestMfaMaxAge(t *testing.T) {
+	j, err := NewEphemeralJwtConf("kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func(data map[string]interface{}) string {
+		t.Helper()
+		data[ClaimUserId] = "u1"
+		tokenStr, err := j.GetToken("host", data, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *tokenStr
+	}
+	upstreamUser := func(c *gin.Context) {
+		SetReqUserToGin(c, NewReqUser("host", "u1", "amy", "Amy", nil, ""))
+	}
+	noop := func(c *gin.Context) {}
+	for _, tc := range []struct {
+		name     string
+		upstream gin.HandlerFunc
+		opts     []BearAuthOption
+		token    string
+		status   int
+	}{
+		{"recent otp", noop, []BearAuthOption{WithTokenParser(j)}, issue(SetMfaClaims(nil, time.Now(), AmrOtp)), http.StatusNoContent},
+		{"no amr", noop, []BearAuthOption{WithTokenParser(j)}, issue(map[string]interface{}{}), errors.Error_Auth_Mfa_Required.GetStatus()},
+		{"expired", noop, []BearAuthOption{WithTokenParser(j)}, issue(SetMfaClaims(nil, time.Now().Add(-time.Hour), AmrOtp)), errors.Error_Auth_Mfa_Expired.GetStatus()},
+		{"upstream user falls back to token", upstreamUser, []BearAuthOption{WithTokenParser(j)}, issue(SetMfaClaims(nil, time.Now(), AmrMfa)), http.StatusNoContent},
+		{"upstream user without parser", upstreamUser, nil, issue(SetMfaClaims(nil, time.Now(), AmrMfa)), errors.Error_Auth_Mfa_Required.GetStatus()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &eventRecorder{}
+			r := newMfaServer(t, tc.upstream, append(tc.opts, WithEventListener(rec))...)
+			req := httptest.NewRequest(http.MethodPost, "/pay", nil)
+			req.Header.Set(BearerAuthTokenKey, "Bearer "+tc.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tc.status {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			if tc.opts == nil {
+				if e := rec.last(); e == nil || e.Detail == "" {
+					t.Fatalf("no hint in the rejection event: %+v", e)
+				}
+			}
+		})
+	}
+}
@@ -6,16 +6,48 @@ import (
 	"net/http"
 	"strings"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/wayne011872/api-toolkit/errors"
 )
 
-func NewGinBearAuthMid(isMatchHost bool) GinAuthMidInter {
-	return &bearAuthMiddle{
+type TokenParser interface {
+	ParseToken(tokenStr string) (*jwt.Token, error)
+}
+
+type BearAuthOption func(*bearAuthMiddle)
+
+// 前面沒有其他 middleware 設定 ReqUser 時, 由 bearer middleware 自行解析 token
+func WithTokenParser(parser TokenParser) BearAuthOption {
+	return func(m *bearAuthMiddle) {
+		m.parser = parser
+	}
+}
+
+// 綁定 cnf.jkt 的 token 必須以 DPoP scheme 並附上有效的 DPoP proof
+func WithDPoP(v DPoPVerifier) BearAuthOption {
+	return func(m *bearAuthMiddle) {
+		m.dpop = v
+	}
+}
+
+func WithEventListener(listeners ...AuthEventListener) BearAuthOption {
+	return func(m *bearAuthMiddle) {
+		m.listeners = append(m.listeners, listeners...)
+	}
+}
+
+func NewGinBearAuthMid(isMatchHost bool, opts ...BearAuthOption) GinAuthMidInter {
+	m := &bearAuthMiddle{
 		authMap:     make(map[string]uint8),
 		groupMap:    make(map[string][]ApiPerm),
+		routeOptMap: make(map[string]RouteAuthOpts),
 		isMatchHost: isMatchHost,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 func (lm *bearAuthMiddle) GetName() string {
@@ -26,7 +58,11 @@ type bearAuthMiddle struct {
 	errors.CommonApiErrorHandler
 	authMap     map[string]uint8
 	groupMap    map[string][]ApiPerm
+	routeOptMap map[string]RouteAuthOpts
 	isMatchHost bool
+	parser      TokenParser
+	dpop        DPoPVerifier
+	listeners   AuthEventListeners
 }
 
 type ctxKey string
@@ -78,6 +114,10 @@ func (am *bearAuthMiddle) AddAuthPath(path string, method string, isAuth bool, g
 	am.groupMap[key] = group
 }
 
+func (am *bearAuthMiddle) SetRouteOpts(path string, method string, opts RouteAuthOpts) {
+	am.routeOptMap[getPathKey(path, method)] = opts
+}
+
 func (am *bearAuthMiddle) IsAuth(path string, method string) bool {
 	key := getPathKey(path, method)
 	value, ok := am.authMap[key]
@@ -101,54 +141,125 @@ func (am *bearAuthMiddle) HasPerm(path, method string, perm []string) bool {
 	return false
 }
 
+// err 回應給 client, cause 為實際原因, 只記錄在事件的 Detail
+func (m *bearAuthMiddle) reject(c *gin.Context, typ AuthEventType, err error, cause error) {
+	e := NewAuthEventFromGin(c, typ, err.Error())
+	if cause != nil {
+		e.Detail = cause.Error()
+	}
+	m.listeners.OnAuthEvent(e)
+	m.GinApiErrorHandler(c, err)
+	c.Abort()
+}
+
 func (m *bearAuthMiddle) Handler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		path := c.FullPath()
 		method := c.Request.Method
 		if path == "" {
-			m.GinApiErrorHandler(c, errors.Error_Auth_Path_NotFound)
-			c.Abort()
+			m.reject(c, EventAuthFailure, errors.Error_Auth_Path_NotFound, nil)
 			return
 		}
 		if m.IsAuth(path, method) {
+			if _, ok := c.Get(_KEY_PRESIGN_GRANT); ok {
+				if !m.routeOptMap[getPathKey(path, method)].Presign {
+					m.reject(c, EventAuthFailure, errors.Error_Auth_Presign_Not_Allowed, nil)
+					return
+				}
+				c.Next()
+				return
+			}
+
 			authToken := c.GetHeader(BearerAuthTokenKey)
 			if authToken == "" {
-				m.GinApiErrorHandler(c, errors.Error_Auth_Miss_Token)
-				c.Abort()
+				m.reject(c, EventAuthFailure, errors.Error_Auth_Miss_Token, nil)
 				return
 			}
 
-			if !strings.HasPrefix(authToken, "Bearer ") {
-				m.GinApiErrorHandler(c, errors.Error_Auth_Invalid_Token)
-				c.Abort()
+			scheme, tokenStr, _ := strings.Cut(authToken, " ")
+			isDPoP := scheme == "DPoP" && m.dpop != nil
+			if scheme != "Bearer" && !isDPoP {
+				m.reject(c, EventAuthFailure, errors.Error_Auth_Invalid_Token, fmt.Errorf("unsupported scheme %q", scheme))
 				return
 			}
 
-			u, ok := c.Get(_KEY_USER_INFO)
-			if !ok {
-				m.GinApiErrorHandler(c, errors.Error_Auth_Miss_Token)
-				c.Abort()
+			var reqUser ReqUser
+			if u, ok := c.Get(_KEY_USER_INFO); ok {
+				reqUser = u.(ReqUser)
+			} else if m.parser != nil {
+				token, err := m.parser.ParseToken(tokenStr)
+				if err == nil && isOneTimeToken(token) {
+					err = fmt.Errorf("one-time token used as bearer token")
+				}
+				if err != nil {
+					m.reject(c, EventAuthFailure, errors.Error_Auth_Invalid_Token, err)
+					return
+				}
+				reqUser = NewReqUserFromToken(token)
+				SetReqUserToGin(c, reqUser)
+				c.Request = c.Request.WithContext(SetReqUserToCtx(c.Request.Context(), reqUser))
+			}
+			if reqUser == nil {
+				m.reject(c, EventAuthFailure, errors.Error_Auth_Miss_Token, nil)
 				return
 			}
-			reqUser := u.(ReqUser)
+
+			if jkt := GetDPoPJkt(reqUser); jkt != "" || isDPoP {
+				if !isDPoP {
+					m.reject(c, EventAuthFailure, errors.Error_Auth_Invalid_DPoP, fmt.Errorf("dpop bound token sent as %s", scheme))
+					return
+				}
+				if jkt == "" {
+					m.reject(c, EventAuthFailure, errors.Error_Auth_Invalid_DPoP, fmt.Errorf("token has no cnf.jkt"))
+					return
+				}
+				if _, err := m.dpop.Verify(c.Request, c.GetHeader(DPoPHeaderKey), tokenStr, jkt); err != nil {
+					m.reject(c, EventAuthFailure, errors.Error_Auth_Invalid_DPoP, err)
+					return
+				}
+			}
 
 			host := getHost(c.Request)
 			if m.isMatchHost && reqUser.GetHost() != host {
-				m.GinApiErrorHandler(c, errors.Error_Auth_Host_Not_Match)
-				c.Abort()
+				m.reject(c, EventAuthFailure, errors.Error_Auth_Host_Not_Match, fmt.Errorf("token host %q, request host %q", reqUser.GetHost(), host))
 				return
 			}
 
 			if hasPerm := m.HasPerm(path, method, reqUser.GetPerms()); !hasPerm {
-				m.GinApiErrorHandler(c, errors.Error_Auth_No_Perm)
-				c.Abort()
+				m.reject(c, EventPermDenied, errors.Error_Auth_No_Perm, nil)
 				return
 			}
+
+			if opts := m.routeOptMap[getPathKey(path, method)]; opts.MfaMaxAge > 0 {
+				mu, cause := m.mfaUser(reqUser, tokenStr)
+				if err := checkMfa(mu, opts.MfaMaxAge); err != nil {
+					m.reject(c, EventAuthFailure, err, cause)
+					return
+				}
+			}
 		}
 		c.Next()
 	}
 }
 
+// 上游 middleware 設定的 ReqUser 沒有實作 MfaReqUser 時, 以 parser 從 token 讀取 amr/auth_time
+// 沒有 parser 時回傳 nil, 請求一律得到 mfa_required, 原因記錄在事件的 Detail
+func (m *bearAuthMiddle) mfaUser(u ReqUser, tokenStr string) (MfaReqUser, error) {
+	if mu, ok := u.(MfaReqUser); ok {
+		return mu, nil
+	}
+	if m.parser == nil {
+		return nil, fmt.Errorf("req user %T does not implement MfaReqUser and no token parser is set", u)
+	}
+	token, err := m.parser.ParseToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	amr, authTime := GetMfaFromClaims(claims)
+	return NewMfaReqUser(u, amr, authTime), nil
+}
+
 func getHost(req *http.Request) string {
 	host := req.Header.Get("X-Forwarded-Host")
 	if host == "" {
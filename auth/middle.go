@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"time"
+
 	"github.com/wayne011872/api-toolkit/mid"
 )
 
@@ -10,3 +12,15 @@ type GinAuthMidInter interface {
 	IsAuth(path string, method string) bool
 	HasPerm(path, method string, perm []string) bool
 }
+
+type RouteAuthOpts struct {
+	// 大於 0 時要求 token 在此時間內完成過 MFA
+	MfaMaxAge time.Duration
+	// 接受 NewGinPresignMid 驗證過的預簽 URL, 不需要 bearer token
+	Presign bool
+}
+
+// 支援路由層級設定的 auth middleware 額外實作此介面
+type GinAuthRouteMidInter interface {
+	SetRouteOpts(path string, method string, opts RouteAuthOpts)
+}
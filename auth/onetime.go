@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	apierr "github.com/wayne011872/api-toolkit/errors"
+)
+
+type TokenPurpose string
+
+const (
+	PurposePasswordReset = TokenPurpose("password_reset")
+	PurposeEmailVerify   = TokenPurpose("email_verify")
+	PurposeMagicLink     = TokenPurpose("magic_link")
+
+	ClaimPurpose = "pur"
+	// one-time token 的 header typ, 與登入用的 token 區分
+	OneTimeTokenType = "ott+jwt"
+)
+
+type OneTimeStore interface {
+	// 第一次使用回傳 true, 已使用過回傳 false; exp 之後紀錄可以清除
+	Use(jti string, exp time.Time) (bool, error)
+}
+
+type OneTimeToken interface {
+	Issue(purpose TokenPurpose, subject string, ttl time.Duration, data map[string]interface{}) (string, error)
+	Verify(purpose TokenPurpose, tokenStr string) (subject string, data map[string]interface{}, err error)
+	AddEventListener(listeners ...AuthEventListener)
+}
+
+// 以 JwtConf 的金鑰簽章, header typ 為 OneTimeTokenType; JwtConf.ParseToken 不接受這類 token
+// 設定 Encryption 時與其他 token 一樣包成 JWE; 沿用 JwtConf 已加入的 event listener
+func NewJwtOneTimeToken(j *JwtConf, store OneTimeStore) OneTimeToken {
+	return &oneTimeToken{
+		store:     store,
+		listeners: append(AuthEventListeners(nil), j.listeners...),
+		sign: func(claims jwt.MapClaims) (string, error) {
+			header := j.newHeader("")
+			header["typ"] = OneTimeTokenType
+			signed, err := j.signWithHeader(claims, header)
+			if err != nil {
+				return "", err
+			}
+			return j.encryptIfNeeded(signed)
+		},
+		decrypt: j.decryptIfNeeded,
+		keyFunc: j.keyFunc,
+	}
+}
+
+func NewHmacOneTimeToken(secret []byte, store OneTimeStore) OneTimeToken {
+	return &oneTimeToken{
+		store: store,
+		sign: func(claims jwt.MapClaims) (string, error) {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			token.Header["typ"] = OneTimeTokenType
+			return token.SignedString(secret)
+		},
+		keyFunc: func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.New("unexpected signing method")
+			}
+			return secret, nil
+		},
+	}
+}
+
+type oneTimeToken struct {
+	store OneTimeStore
+	sign  func(claims jwt.MapClaims) (string, error)
+	// 解開 JWE, 沒有加密時為 nil
+	decrypt   func(tokenStr string) (string, error)
+	keyFunc   jwt.Keyfunc
+	listeners AuthEventListeners
+}
+
+func (o *oneTimeToken) AddEventListener(listeners ...AuthEventListener) {
+	o.listeners = append(o.listeners, listeners...)
+}
+
+// 事件的 Reason 為 purpose, 失敗時 Detail 為實際原因
+func (o *oneTimeToken) emitEvent(typ AuthEventType, purpose TokenPurpose, subject string, cause error) {
+	if len(o.listeners) == 0 {
+		return
+	}
+	e := NewAuthEvent(typ, nil)
+	e.UserId = subject
+	e.Reason = string(purpose)
+	if cause != nil {
+		e.Detail = cause.Error()
+	}
+	o.listeners.OnAuthEvent(e)
+}
+
+func newTokenId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (o *oneTimeToken) Issue(purpose TokenPurpose, subject string, ttl time.Duration, data map[string]interface{}) (string, error) {
+	if purpose == "" || subject == "" {
+		return "", errors.New("missing purpose or subject")
+	}
+	if ttl <= 0 {
+		return "", errors.New("invalid ttl")
+	}
+	jti, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{}
+	for k, v := range data {
+		claims[k] = v
+	}
+	now := time.Now()
+	claims[ClaimPurpose] = string(purpose)
+	claims[ClaimUserId] = subject
+	claims[ClaimTokenId] = jti
+	claims["iat"] = now.Unix()
+	claims[ClaimExpire] = now.Add(ttl).Unix()
+	tokenStr, err := o.sign(claims)
+	if err != nil {
+		return "", err
+	}
+	o.emitEvent(EventOneTimeIssue, purpose, subject, nil)
+	return tokenStr, nil
+}
+
+func (o *oneTimeToken) Verify(purpose TokenPurpose, tokenStr string) (string, map[string]interface{}, error) {
+	subject, data, cause, err := o.verify(purpose, tokenStr)
+	if err != nil {
+		o.emitEvent(EventAuthFailure, purpose, subject, cause)
+		return "", nil, err
+	}
+	o.emitEvent(EventOneTimeUsed, purpose, subject, nil)
+	return subject, data, nil
+}
+
+// 失敗時 err 回傳給呼叫端, cause 為記錄在事件的實際原因; subject 盡量保留供事件記錄
+func (o *oneTimeToken) verify(purpose TokenPurpose, tokenStr string) (subject string, data map[string]interface{}, cause error, err error) {
+	if o.decrypt != nil {
+		var derr error
+		if tokenStr, derr = o.decrypt(tokenStr); derr != nil {
+			return "", nil, derr, apierr.Error_OneTime_Invalid
+		}
+	}
+	token, perr := jwt.Parse(tokenStr, o.keyFunc)
+	if perr != nil {
+		if ve, ok := perr.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+			return "", nil, perr, apierr.Error_OneTime_Expired
+		}
+		return "", nil, perr, apierr.Error_OneTime_Invalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || token.Header["typ"] != OneTimeTokenType {
+		return "", nil, errors.Errorf("unexpected typ %v", token.Header["typ"]), apierr.Error_OneTime_Invalid
+	}
+	subject = claimString(claims, ClaimUserId)
+	jti := claimString(claims, ClaimTokenId)
+	exp, hasExp := claimInt64(claims[ClaimExpire])
+	if pur := claimString(claims, ClaimPurpose); pur != string(purpose) {
+		return subject, nil, errors.Errorf("purpose %q, want %q", pur, purpose), apierr.Error_OneTime_Invalid
+	}
+	if subject == "" || jti == "" || !hasExp {
+		return subject, nil, errors.New("missing sub, jti or exp"), apierr.Error_OneTime_Invalid
+	}
+	first, uerr := o.store.Use(jti, time.Unix(exp, 0))
+	if uerr != nil {
+		return subject, nil, uerr, uerr
+	}
+	if !first {
+		return subject, nil, apierr.Error_OneTime_Used, apierr.Error_OneTime_Used
+	}
+	for _, k := range []string{ClaimPurpose, ClaimUserId, ClaimTokenId, ClaimExpire, "iat"} {
+		delete(claims, k)
+	}
+	return subject, claims, nil, nil
+}
+
+// 帶有 pur claim 或 one-time typ 的 token 只能交給 OneTimeToken.Verify
+func isOneTimeToken(token *jwt.Token) bool {
+	if token.Header["typ"] == OneTimeTokenType {
+		return true
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	_, ok = claims[ClaimPurpose]
+	return ok
+}
+
+func NewMemOneTimeStore() OneTimeStore {
+	return &memOneTimeStore{
+		used: make(map[string]time.Time),
+	}
+}
+
+type memOneTimeStore struct {
+	lock      sync.Mutex
+	used      map[string]time.Time
+	lastClean time.Time
+}
+
+func (s *memOneTimeStore) Use(jti string, exp time.Time) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastClean) > time.Minute {
+		s.lastClean = now
+		for k, v := range s.used {
+			if now.After(v) {
+				delete(s.used, k)
+			}
+		}
+	}
+	if _, ok := s.used[jti]; ok {
+		return false, nil
+	}
+	s.used[jti] = exp
+	return true, nil
+}
+
+type OneTimeMessage struct {
+	To        string       `json:"to"`
+	Purpose   TokenPurpose `json:"purpose"`
+	Link      string       `json:"link"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+type Sender interface {
+	Send(ctx context.Context, msg *OneTimeMessage) error
+}
+
+// 簽發 token 並把帶有 token 參數的連結寄給 to
+func SendOneTimeLink(ctx context.Context, ott OneTimeToken, sender Sender, to string, purpose TokenPurpose, subject string, ttl time.Duration, baseURL string) error {
+	tokenStr, err := ott.Issue(purpose, subject, ttl, nil)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("token", tokenStr)
+	u.RawQuery = q.Encode()
+	return sender.Send(ctx, &OneTimeMessage{
+		To:        to,
+		Purpose:   purpose,
+		Link:      u.String(),
+		CreatedAt: time.Now(),
+	})
+}
+
+// 測試用, 寄出的訊息保留在記憶體
+type MemSender interface {
+	Sender
+	Messages() []*OneTimeMessage
+	LastMessage(to string) *OneTimeMessage
+}
+
+func NewMemSender() MemSender {
+	return &memSender{}
+}
+
+type memSender struct {
+	lock sync.Mutex
+	msgs []*OneTimeMessage
+}
+
+func (s *memSender) Send(ctx context.Context, msg *OneTimeMessage) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func (s *memSender) Messages() []*OneTimeMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*OneTimeMessage(nil), s.msgs...)
+}
+
+func (s *memSender) LastMessage(to string) *OneTimeMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := len(s.msgs) - 1; i >= 0; i-- {
+		if s.msgs[i].To == to {
+			return s.msgs[i]
+		}
+	}
+	return nil
+}
+
+// 本機開發用, 每則訊息以一行 JSON 附加到檔案
+func NewFileSender(path string) Sender {
+	return &fileSender{path: path}
+}
+
+type fileSender struct {
+	lock sync.Mutex
+	path string
+}
+
+func (s *fileSender) Send(ctx context.Context, msg *OneTimeMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
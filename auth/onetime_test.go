@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestOneTimeTokenNotAcceptedAsBearer(t *testing.T) {
+	j, err := NewEphemeralJwtConf("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ott := NewJwtOneTimeToken(j, NewMemOneTimeStore())
+	tokenStr, err := ott.Issue(PurposePasswordReset, "u1", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = j.ParseToken(tokenStr); err == nil {
+		t.Fatal("ParseToken accepted a one-time token")
+	}
+	if _, err = j.ParseTokenUnValidate(tokenStr); err == nil {
+		t.Fatal("ParseTokenUnValidate accepted a one-time token")
+	}
+
+	sub, _, err := ott.Verify(PurposePasswordReset, tokenStr)
+	if err != nil || sub != "u1" {
+		t.Fatalf("Verify = %q, %v", sub, err)
+	}
+	if _, _, err = ott.Verify(PurposePasswordReset, tokenStr); err == nil {
+		t.Fatal("one-time token verified twice")
+	}
+}
+
+func TestOneTimeVerifyRejectsLoginToken(t *testing.T) {
+	j, err := NewEphemeralJwtConf("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenStr, err := j.GetToken("host", map[string]interface{}{
+		ClaimUserId:  "u1",
+		ClaimPurpose: string(PurposeMagicLink),
+		ClaimTokenId: "jti",
+	}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ott := NewJwtOneTimeToken(j, NewMemOneTimeStore())
+	if _, _, err = ott.Verify(PurposeMagicLink, *tokenStr); err == nil {
+		t.Fatal("Verify accepted a token without the one-time typ")
+	}
+	if _, err = j.ParseToken(*tokenStr); err == nil {
+		t.Fatal("ParseToken accepted a token carrying pur")
+	}
+}
+
+func TestJwtOneTimeTokenEncrypted(t *testing.T) {
+	j, err := NewEphemeralJwtConf("kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Encryption.Alg = JweAlgEcdhEs
+	j.jwePrivateKey = key
+	j.jwePublicKey = key.Public()
+
+	ott := NewJwtOneTimeToken(j, NewMemOneTimeStore())
+	tokenStr, err := ott.Issue(PurposeMagicLink, "u1", time.Minute, map[string]interface{}{"email": "amy@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isJwe(tokenStr) {
+		t.Fatalf("one-time token sent in cleartext: %s", tokenStr)
+	}
+	sub, data, err := ott.Verify(PurposeMagicLink, tokenStr)
+	if err != nil || sub != "u1" || data["email"] != "amy@example.com" {
+		t.Fatalf("Verify = %q, %v, %v", sub, data, err)
+	}
+}
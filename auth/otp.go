@@ -1,12 +1,16 @@
 package auth
 
 import (
-	"fmt"
 	"image/png"
 	"io"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
 	"github.com/pquerna/otp/totp"
 )
 
@@ -14,15 +18,112 @@ type Totp interface {
 	GenerateCode() (string, error)
 	ValidateCode(code string) (valid bool, err error)
 	WriteQRCode(w io.Writer) error
-	ShowInfo() error
+	ShowInfo() (*OtpInfo, error)
+}
+
+type Hotp interface {
+	GenerateCode() (string, error)
+	// 驗證成功後計數器會前進到下一個值
+	ValidateCode(code string) (valid bool, err error)
+	// 以連續兩組密碼重新同步計數器
+	Resync(code1, code2 string) (ok bool, err error)
+	GetCounter() uint64
+	WriteQRCode(w io.Writer) error
+	ShowInfo() (*OtpInfo, error)
+}
+
+type OtpOpts struct {
+	// 預設 6 位數
+	Digits otp.Digits
+	// 預設 SHA1
+	Algorithm otp.Algorithm
+	// TOTP 允許前後偏移的週期數, HOTP 則為驗證時往後找的計數範圍, 預設 1
+	Skew uint
+	// TOTP 週期秒數, 預設 30
+	Period uint
+	// HOTP 重新同步時往後找的計數範圍, 預設 100
+	ResyncWindow uint
+	// 每次 ValidateCode 後發出 mfa_success / mfa_failure
+	Listener AuthEventListener
+	// HOTP 驗證或重新同步成功後保存新的計數器, 下次以 NewHotp 傳入
+	CounterStore HotpCounterStore
+}
+
+type HotpCounterStore interface {
+	SaveCounter(account string, counter uint64) error
+}
+
+func (o OtpOpts) emitValidate(account string, valid bool) {
+	if o.Listener == nil {
+		return
+	}
+	e := NewAuthEvent(EventMfaFailure, nil)
+	if valid {
+		e.Type = EventMfaSuccess
+	}
+	e.Account = account
+	o.Listener.OnAuthEvent(e)
+}
+
+func (o OtpOpts) withDefault() OtpOpts {
+	if o.Digits == 0 {
+		o.Digits = otp.DigitsSix
+	}
+	if o.Skew == 0 {
+		o.Skew = 1
+	}
+	if o.Period == 0 {
+		o.Period = 30
+	}
+	if o.ResyncWindow == 0 {
+		o.ResyncWindow = 100
+	}
+	return o
+}
+
+type OtpInfo struct {
+	Type        string `json:"type"`
+	Issuer      string `json:"issuer"`
+	AccountName string `json:"accountName"`
+	Secret      string `json:"secret"`
+	Digits      int    `json:"digits"`
+	Algorithm   string `json:"algorithm"`
+	Period      uint   `json:"period,omitempty"`
+	Counter     uint64 `json:"counter,omitempty"`
+	URL         string `json:"url"`
+}
+
+func newOtpInfo(key *otp.Key) *OtpInfo {
+	return &OtpInfo{
+		Type:        key.Type(),
+		Issuer:      key.Issuer(),
+		AccountName: key.AccountName(),
+		Secret:      key.Secret(),
+		Digits:      key.Digits().Length(),
+		Algorithm:   key.Algorithm().String(),
+		URL:         key.URL(),
+	}
+}
+
+func writeQRCode(key *otp.Key, w io.Writer) error {
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
 }
 
 func NewTotp(host, account, secret string, PeriodSecs uint) Totp {
+	return NewTotpWithOpts(host, account, secret, OtpOpts{Period: PeriodSecs})
+}
+
+func NewTotpWithOpts(host, account, secret string, opts OtpOpts) Totp {
+	opts = opts.withDefault()
 	return &totpConf{
 		Host:    host,
 		Account: account,
 		Secret:  secret,
-		Period:  PeriodSecs,
+		Opts:    opts,
 	}
 }
 
@@ -30,7 +131,7 @@ type totpConf struct {
 	Host    string
 	Account string
 	Secret  string
-	Period  uint
+	Opts    OtpOpts
 }
 
 func (tc *totpConf) generateKey() (*otp.Key, error) {
@@ -38,21 +139,27 @@ func (tc *totpConf) generateKey() (*otp.Key, error) {
 		Issuer:      tc.Host,
 		AccountName: tc.Account,
 		Secret:      []byte(tc.Secret),
-		Period:      tc.Period,
+		Period:      tc.Opts.Period,
+		Digits:      tc.Opts.Digits,
+		Algorithm:   tc.Opts.Algorithm,
 	})
 }
 
+func (tc *totpConf) validateOpts() totp.ValidateOpts {
+	return totp.ValidateOpts{
+		Period:    tc.Opts.Period,
+		Skew:      tc.Opts.Skew,
+		Digits:    tc.Opts.Digits,
+		Algorithm: tc.Opts.Algorithm,
+	}
+}
+
 func (tc *totpConf) GenerateCode() (code string, err error) {
 	key, err := tc.generateKey()
 	if key == nil {
 		return
 	}
-	code, err = totp.GenerateCodeCustom(key.Secret(), time.Now().UTC(), totp.ValidateOpts{
-		Period:    tc.Period,
-		Skew:      1,
-		Digits:    otp.DigitsSix,
-		Algorithm: otp.AlgorithmSHA1,
-	})
+	code, err = totp.GenerateCodeCustom(key.Secret(), time.Now().UTC(), tc.validateOpts())
 	return
 }
 
@@ -65,13 +172,9 @@ func (tc *totpConf) ValidateCode(code string) (valid bool, err error) {
 		code,
 		key.Secret(),
 		time.Now().UTC(),
-		totp.ValidateOpts{
-			Period:    tc.Period,
-			Skew:      1,
-			Digits:    otp.DigitsSix,
-			Algorithm: otp.AlgorithmSHA1,
-		},
+		tc.validateOpts(),
 	)
+	tc.Opts.emitValidate(tc.Account, valid)
 	return
 }
 
@@ -80,20 +183,159 @@ func (tc *totpConf) WriteQRCode(w io.Writer) error {
 	if key == nil {
 		return err
 	}
-	img, err := key.Image(200, 200)
+	return writeQRCode(key, w)
+}
+
+func (tc *totpConf) ShowInfo() (*OtpInfo, error) {
+	key, err := tc.generateKey()
+	if key == nil {
+		return nil, err
+	}
+	info := newOtpInfo(key)
+	info.Period = tc.Opts.Period
+	return info, nil
+}
+
+// counter 為上次成功驗證後保存的計數器值
+func NewHotp(host, account, secret string, counter uint64, opts OtpOpts) Hotp {
+	opts = opts.withDefault()
+	return &hotpConf{
+		Host:    host,
+		Account: account,
+		Secret:  secret,
+		Opts:    opts,
+		counter: counter,
+	}
+}
+
+type hotpConf struct {
+	Host    string
+	Account string
+	Secret  string
+	Opts    OtpOpts
+
+	lock    sync.Mutex
+	counter uint64
+}
+
+func (hc *hotpConf) generateKey() (*otp.Key, error) {
+	key, err := hotp.Generate(hotp.GenerateOpts{
+		Issuer:      hc.Host,
+		AccountName: hc.Account,
+		Secret:      []byte(hc.Secret),
+		Digits:      hc.Opts.Digits,
+		Algorithm:   hc.Opts.Algorithm,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return png.Encode(w, img)
+	// hotp.Generate 不會帶 counter, authenticator 需要它才能同步
+	u, err := url.Parse(key.URL())
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("counter", strconv.FormatUint(hc.GetCounter(), 10))
+	u.RawQuery = q.Encode()
+	return otp.NewKeyFromURL(u.String())
 }
 
-func (tc *totpConf) ShowInfo() error {
-	key, err := tc.generateKey()
+func (hc *hotpConf) validateOpts() hotp.ValidateOpts {
+	return hotp.ValidateOpts{
+		Digits:    hc.Opts.Digits,
+		Algorithm: hc.Opts.Algorithm,
+	}
+}
+
+func (hc *hotpConf) GetCounter() uint64 {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	return hc.counter
+}
+
+func (hc *hotpConf) GenerateCode() (string, error) {
+	key, err := hc.generateKey()
+	if key == nil {
+		return "", err
+	}
+	return hotp.GenerateCodeCustom(key.Secret(), hc.GetCounter(), hc.validateOpts())
+}
+
+// 找出 code 在 [from, from+window] 範圍內對應的計數器
+func (hc *hotpConf) lookup(secret, code string, from uint64, window uint) (uint64, bool, error) {
+	for i := uint64(0); i <= uint64(window); i++ {
+		valid, err := hotp.ValidateCustom(code, from+i, secret, hc.validateOpts())
+		if err != nil {
+			return 0, false, err
+		}
+		if valid {
+			return from + i, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func (hc *hotpConf) ValidateCode(code string) (valid bool, err error) {
+	key, err := hc.generateKey()
+	if key == nil {
+		return
+	}
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	counter, valid, err := hc.lookup(key.Secret(), code, hc.counter, hc.Opts.Skew)
+	if valid {
+		err = hc.setCounter(counter + 1)
+		valid = err == nil
+	}
+	hc.Opts.emitValidate(hc.Account, valid)
+	return
+}
+
+// 保存失敗時記憶體中的計數器仍會前進, 已用過的 code 不能再使用
+func (hc *hotpConf) setCounter(counter uint64) error {
+	hc.counter = counter
+	if hc.Opts.CounterStore == nil {
+		return nil
+	}
+	return hc.Opts.CounterStore.SaveCounter(hc.Account, counter)
+}
+
+func (hc *hotpConf) Resync(code1, code2 string) (ok bool, err error) {
+	if code1 == "" || code2 == "" {
+		return false, errors.New("missing code")
+	}
+	key, err := hc.generateKey()
+	if key == nil {
+		return
+	}
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	counter, found, err := hc.lookup(key.Secret(), code1, hc.counter, hc.Opts.ResyncWindow)
+	if !found {
+		return false, err
+	}
+	ok, err = hotp.ValidateCustom(code2, counter+1, key.Secret(), hc.validateOpts())
+	if ok {
+		err = hc.setCounter(counter + 2)
+		ok = err == nil
+	}
+	return
+}
+
+func (hc *hotpConf) WriteQRCode(w io.Writer) error {
+	key, err := hc.generateKey()
 	if key == nil {
 		return err
 	}
-	fmt.Printf("Issuer:       %s\n", key.Issuer())
-	fmt.Printf("Account Name: %s\n", key.AccountName())
-	fmt.Printf("Secret:       %s\n", key.Secret())
-	return nil
+	return writeQRCode(key, w)
+}
+
+func (hc *hotpConf) ShowInfo() (*OtpInfo, error) {
+	key, err := hc.generateKey()
+	if key == nil {
+		return nil, err
+	}
+	info := newOtpInfo(key)
+	info.Counter = hc.GetCounter()
+	return info, nil
 }
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"testing"
+)
+
+// RFC 4226 附錄 D 的測試向量
+const rfc4226Secret = "12345678901234567890"
+
+var rfc4226Codes = []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+type memCounterStore map[string]uint64
+
+func (s memCounterStore) SaveCounter(account string, counter uint64) error {
+	s[account] = counter
+	return nil
+}
+
+func TestHotpGenerateCode(t *testing.T) {
+	for counter, want := range rfc4226Codes {
+		code, err := NewHotp("host", "amy", rfc4226Secret, uint64(counter), OtpOpts{}).GenerateCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("counter %d: code %s, want %s", counter, code, want)
+		}
+	}
+}
+
+func TestHotpValidateWindow(t *testing.T) {
+	store := memCounterStore{}
+	h := NewHotp("host", "amy", rfc4226Secret, 0, OtpOpts{CounterStore: store})
+
+	// Skew 預設 1, 只往後找一個計數器
+	if valid, err := h.ValidateCode(rfc4226Codes[2]); err != nil || valid {
+		t.Fatalf("code outside the window: valid %v, err %v", valid, err)
+	}
+	if valid, err := h.ValidateCode(rfc4226Codes[1]); err != nil || !valid {
+		t.Fatalf("code inside the window: valid %v, err %v", valid, err)
+	}
+	if h.GetCounter() != 2 || store["amy"] != 2 {
+		t.Fatalf("counter %d, saved %d", h.GetCounter(), store["amy"])
+	}
+	if valid, _ := h.ValidateCode(rfc4226Codes[1]); valid {
+		t.Fatal("used code accepted again")
+	}
+}
+
+func TestHotpResync(t *testing.T) {
+	store := memCounterStore{}
+	h := NewHotp("host", "amy", rfc4226Secret, 0, OtpOpts{CounterStore: store})
+	if ok, err := h.Resync(rfc4226Codes[6], rfc4226Codes[8]); err != nil || ok {
+		t.Fatalf("non-consecutive codes: ok %v, err %v", ok, err)
+	}
+	if ok, err := h.Resync(rfc4226Codes[6], rfc4226Codes[7]); err != nil || !ok {
+		t.Fatalf("consecutive codes: ok %v, err %v", ok, err)
+	}
+	if h.GetCounter() != 8 || store["amy"] != 8 {
+		t.Fatalf("counter %d, saved %d", h.GetCounter(), store["amy"])
+	}
+	if valid, err := h.ValidateCode(rfc4226Codes[8]); err != nil || !valid {
+		t.Fatalf("next code after resync: valid %v, err %v", valid, err)
+	}
+
+	h = NewHotp("host", "amy", rfc4226Secret, 0, OtpOpts{ResyncWindow: 3})
+	if ok, _ := h.Resync(rfc4226Codes[6], rfc4226Codes[7]); ok {
+		t.Fatal("resync beyond ResyncWindow accepted")
+	}
+}
@@ -0,0 +1,603 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	PasetoV4Public = "v4.public"
+	PasetoV4Local  = "v4.local"
+
+	pasetoPrefix  = "v4."
+	usageRefresh  = "refresh"
+	pasetoNonce   = 32
+	pasetoTagSize = 32
+)
+
+// PASETO v4, 提供與 JwtConf 相同的 JwtToken 介面
+// ParseToken 回傳的 *jwt.Token 中 exp/iat/nbf 已轉成 unix 秒, 可直接給 NewReqUserFromToken 使用
+type PasetoConf struct {
+	// v4.public (Ed25519 簽章) 或 v4.local (XChaCha20 + BLAKE2b 加密)
+	Purpose string `yaml:"purpose"`
+	Kid     string `yaml:"kid"`
+	// v4.public 使用的 Ed25519 金鑰 (PKCS8/PKIX PEM)
+	PrivateKeyFile string `yaml:"privatekey"`
+	PublicKeyFile  string `yaml:"publickey"`
+	PrivateKeyEnv  string `yaml:"privatekey_env"`
+	PublicKeyEnv   string `yaml:"publickey_env"`
+	// v4.local 使用的 32 bytes 金鑰, hex 或 base64
+	LocalKey    string `yaml:"local_key"`
+	LocalKeyEnv string `yaml:"local_key_env"`
+	// refresh token 以此衍生的金鑰做 v4.local 加密
+	RefreshSecret string `yaml:"refresh_secret"`
+
+	keyMu        sync.Mutex
+	privateKey   ed25519.PrivateKey
+	publicKey    ed25519.PublicKey
+	localKey     []byte
+	sessionStore SessionStore
+	listeners    AuthEventListeners
+}
+
+func NewPasetoPublicConf(kid string, key ed25519.PrivateKey) *PasetoConf {
+	return &PasetoConf{
+		Purpose:    PasetoV4Public,
+		Kid:        kid,
+		privateKey: key,
+		publicKey:  key.Public().(ed25519.PublicKey),
+	}
+}
+
+// 只能驗證, 無法簽發
+func NewPasetoVerifyConf(kid string, key ed25519.PublicKey) *PasetoConf {
+	return &PasetoConf{
+		Purpose:   PasetoV4Public,
+		Kid:       kid,
+		publicKey: key,
+	}
+}
+
+func NewPasetoLocalConf(kid string, key []byte) (*PasetoConf, error) {
+	if len(key) != chacha20.KeySize {
+		return nil, errors.New("v4.local key must be 32 bytes")
+	}
+	return &PasetoConf{
+		Purpose:  PasetoV4Local,
+		Kid:      kid,
+		localKey: append([]byte(nil), key...),
+	}, nil
+}
+
+func IsPaseto(tokenStr string) bool {
+	return strings.HasPrefix(tokenStr, pasetoPrefix)
+}
+
+func (p *PasetoConf) GetKid() string {
+	return p.Kid
+}
+
+func (p *PasetoConf) NewJwt() JwtToken {
+	return p
+}
+
+func (p *PasetoConf) SetSessionStore(store SessionStore) {
+	p.sessionStore = store
+}
+
+func (p *PasetoConf) AddEventListener(listeners ...AuthEventListener) {
+	p.listeners = append(p.listeners, listeners...)
+}
+
+func (p *PasetoConf) loadKeys() error {
+	p.keyMu.Lock()
+	defer p.keyMu.Unlock()
+	var err error
+	switch p.Purpose {
+	case PasetoV4Public:
+		if p.privateKey == nil && hasKeySource(p.PrivateKeyEnv, p.PrivateKeyFile) {
+			signer, err := loadPrivateKey(p.PrivateKeyEnv, p.PrivateKeyFile)
+			if err != nil {
+				return err
+			}
+			key, ok := signer.(ed25519.PrivateKey)
+			if !ok {
+				return errors.New("v4.public requires an Ed25519 private key")
+			}
+			p.privateKey = key
+		}
+		if p.publicKey == nil {
+			if hasKeySource(p.PublicKeyEnv, p.PublicKeyFile) {
+				pub, err := loadPublicKey(p.PublicKeyEnv, p.PublicKeyFile)
+				if err != nil {
+					return err
+				}
+				key, ok := pub.(ed25519.PublicKey)
+				if !ok {
+					return errors.New("v4.public requires an Ed25519 public key")
+				}
+				p.publicKey = key
+			} else if p.privateKey != nil {
+				p.publicKey = p.privateKey.Public().(ed25519.PublicKey)
+			} else {
+				return errNoKeySource
+			}
+		}
+	case PasetoV4Local:
+		if p.localKey != nil {
+			return nil
+		}
+		v := p.LocalKey
+		if p.LocalKeyEnv != "" {
+			if env := strings.TrimSpace(os.Getenv(p.LocalKeyEnv)); env != "" {
+				v = env
+			}
+		}
+		if p.localKey, err = decodeLocalKey(v); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unsupported paseto purpose: %s", p.Purpose)
+	}
+	return nil
+}
+
+func decodeLocalKey(v string) ([]byte, error) {
+	if v == "" {
+		return nil, errNoKeySource
+	}
+	if b, err := hex.DecodeString(v); err == nil && len(b) == chacha20.KeySize {
+		return b, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(v); err == nil && len(b) == chacha20.KeySize {
+			return b, nil
+		}
+	}
+	return nil, errors.New("v4.local key must be 32 bytes in hex or base64")
+}
+
+func (p *PasetoConf) refreshReady() error {
+	if p.RefreshSecret == "" {
+		return errors.New("refresh secret not set")
+	}
+	return nil
+}
+
+func (p *PasetoConf) refreshKey() ([]byte, error) {
+	if p.RefreshSecret == "" {
+		return nil, errors.New("refresh secret not set")
+	}
+	key := blake2b.Sum256([]byte(p.RefreshSecret))
+	return key[:], nil
+}
+
+// PASETO 的 pre-authentication encoding
+func pae(pieces ...[]byte) []byte {
+	buf := make([]byte, 8, 64)
+	binary.LittleEndian.PutUint64(buf, uint64(len(pieces)))
+	for _, piece := range pieces {
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(piece))&(1<<63-1))
+		buf = append(buf, n[:]...)
+		buf = append(buf, piece...)
+	}
+	return buf
+}
+
+func pasetoEncode(header string, body, footer []byte) string {
+	s := header + "." + base64.RawURLEncoding.EncodeToString(body)
+	if len(footer) > 0 {
+		s += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+	return s
+}
+
+func pasetoDecode(header, tokenStr string) (body, footer []byte, err error) {
+	if !strings.HasPrefix(tokenStr, header+".") {
+		return nil, nil, errors.Errorf("not a %s token", header)
+	}
+	parts := strings.Split(tokenStr[len(header)+1:], ".")
+	if len(parts) > 2 {
+		return nil, nil, errors.New("malformed paseto")
+	}
+	// 拒絕非標準編碼 (多餘的 padding bits), 避免同一 token 有多種寫法
+	b64 := base64.RawURLEncoding.Strict()
+	if body, err = b64.DecodeString(parts[0]); err != nil {
+		return nil, nil, errors.Wrap(err, "malformed paseto")
+	}
+	if len(parts) == 2 {
+		if footer, err = b64.DecodeString(parts[1]); err != nil {
+			return nil, nil, errors.Wrap(err, "malformed paseto footer")
+		}
+	}
+	return body, footer, nil
+}
+
+func pasetoSign(key ed25519.PrivateKey, m, f []byte) string {
+	h := PasetoV4Public + "."
+	sig := ed25519.Sign(key, pae([]byte(h), m, f, nil))
+	return pasetoEncode(PasetoV4Public, append(append([]byte(nil), m...), sig...), f)
+}
+
+func pasetoVerify(key ed25519.PublicKey, tokenStr string) (m, f []byte, err error) {
+	body, f, err := pasetoDecode(PasetoV4Public, tokenStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < ed25519.SignatureSize {
+		return nil, nil, errors.New("malformed paseto")
+	}
+	m, sig := body[:len(body)-ed25519.SignatureSize], body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(key, pae([]byte(PasetoV4Public+"."), m, f, nil), sig) {
+		return nil, nil, errors.New("invalid paseto signature")
+	}
+	return m, f, nil
+}
+
+func pasetoLocalKeys(key, n []byte) (ek, n2, ak []byte, err error) {
+	h, err := blake2b.New(chacha20.KeySize+chacha20.NonceSizeX, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	h.Write([]byte("paseto-encryption-key"))
+	h.Write(n)
+	tmp := h.Sum(nil)
+
+	h, err = blake2b.New256(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	h.Write([]byte("paseto-auth-key-for-aead"))
+	h.Write(n)
+	return tmp[:chacha20.KeySize], tmp[chacha20.KeySize:], h.Sum(nil), nil
+}
+
+func pasetoTag(ak []byte, pieces ...[]byte) ([]byte, error) {
+	h, err := blake2b.New256(ak)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(pae(pieces...))
+	return h.Sum(nil), nil
+}
+
+func pasetoEncrypt(key, m, f []byte) (string, error) {
+	n := make([]byte, pasetoNonce)
+	if _, err := rand.Read(n); err != nil {
+		return "", err
+	}
+	return pasetoEncryptWithNonce(key, n, m, f)
+}
+
+// nonce 由呼叫端提供, 只有測試向量需要固定 nonce
+func pasetoEncryptWithNonce(key, n, m, f []byte) (string, error) {
+	ek, n2, ak, err := pasetoLocalKeys(key, n)
+	if err != nil {
+		return "", err
+	}
+	stream, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return "", err
+	}
+	c := make([]byte, len(m))
+	stream.XORKeyStream(c, m)
+	t, err := pasetoTag(ak, []byte(PasetoV4Local+"."), n, c, f, nil)
+	if err != nil {
+		return "", err
+	}
+	body := make([]byte, 0, len(n)+len(c)+len(t))
+	body = append(append(append(body, n...), c...), t...)
+	return pasetoEncode(PasetoV4Local, body, f), nil
+}
+
+func pasetoDecrypt(key []byte, tokenStr string) (m, f []byte, err error) {
+	body, f, err := pasetoDecode(PasetoV4Local, tokenStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) < pasetoNonce+pasetoTagSize {
+		return nil, nil, errors.New("malformed paseto")
+	}
+	n, c, t := body[:pasetoNonce], body[pasetoNonce:len(body)-pasetoTagSize], body[len(body)-pasetoTagSize:]
+	ek, n2, ak, err := pasetoLocalKeys(key, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	t2, err := pasetoTag(ak, []byte(PasetoV4Local+"."), n, c, f, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hmac.Equal(t, t2) {
+		return nil, nil, errors.New("invalid paseto tag")
+	}
+	stream, err := chacha20.NewUnauthenticatedCipher(ek, n2)
+	if err != nil {
+		return nil, nil, err
+	}
+	m = make([]byte, len(c))
+	stream.XORKeyStream(m, c)
+	return m, f, nil
+}
+
+type pasetoFooter struct {
+	Kid   string `json:"kid,omitempty"`
+	Usage string `json:"usa,omitempty"`
+}
+
+// exp/iat/nbf 依 PASETO 慣例以 RFC 3339 字串存放
+var pasetoTimeClaims = []string{"exp", "iat", "nbf"}
+
+func encodePasetoClaims(claims map[string]interface{}) ([]byte, error) {
+	payload := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		payload[k] = v
+	}
+	for _, k := range pasetoTimeClaims {
+		if sec, ok := claimInt64(payload[k]); ok {
+			payload[k] = time.Unix(sec, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return json.Marshal(payload)
+}
+
+func (p *PasetoConf) seal(claims map[string]interface{}, usage string) (string, error) {
+	m, err := encodePasetoClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	var f []byte
+	if p.Kid != "" || usage != "" {
+		if f, err = json.Marshal(pasetoFooter{Kid: p.Kid, Usage: usage}); err != nil {
+			return "", err
+		}
+	}
+	if err = p.loadKeys(); err != nil {
+		return "", err
+	}
+	if p.Purpose == PasetoV4Local {
+		return pasetoEncrypt(p.localKey, m, f)
+	}
+	if p.privateKey == nil {
+		return "", errors.New("private key not set")
+	}
+	return pasetoSign(p.privateKey, m, f), nil
+}
+
+func (p *PasetoConf) open(tokenStr string) (claims map[string]interface{}, footer pasetoFooter, err error) {
+	if err = p.loadKeys(); err != nil {
+		return nil, footer, err
+	}
+	var m, f []byte
+	if p.Purpose == PasetoV4Local {
+		m, f, err = pasetoDecrypt(p.localKey, tokenStr)
+	} else {
+		m, f, err = pasetoVerify(p.publicKey, tokenStr)
+	}
+	if err != nil {
+		return nil, footer, err
+	}
+	if len(f) > 0 {
+		if err = json.Unmarshal(f, &footer); err != nil {
+			return nil, footer, errors.Wrap(err, "invalid paseto footer")
+		}
+	}
+	if claims, err = decodePasetoClaims(m); err != nil {
+		return nil, footer, err
+	}
+	return claims, footer, nil
+}
+
+func decodePasetoClaims(m []byte) (map[string]interface{}, error) {
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(m, &claims); err != nil {
+		return nil, errors.Wrap(err, "invalid paseto payload")
+	}
+	for _, k := range pasetoTimeClaims {
+		s, ok := claims[k].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, errors.Errorf("invalid %s claim", k)
+		}
+		claims[k] = float64(t.Unix())
+	}
+	return claims, nil
+}
+
+func validatePasetoTime(claims jwt.MapClaims) error {
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, false) || !claims.VerifyNotBefore(now, false) {
+		return errors.New("Timing is everything")
+	}
+	return nil
+}
+
+// 讓 ParseToken 回傳的 *jwt.Token 帶有可辨識的演算法名稱, 不能用來簽章或驗證
+type pasetoMethod string
+
+func (m pasetoMethod) Alg() string {
+	return string(m)
+}
+
+func (m pasetoMethod) Verify(signingString, signature string, key interface{}) error {
+	return errors.New("paseto method cannot verify jws")
+}
+
+func (m pasetoMethod) Sign(signingString string, key interface{}) (string, error) {
+	return "", errors.New("paseto method cannot sign jws")
+}
+
+func (p *PasetoConf) toJwtToken(tokenStr string, claims map[string]interface{}, footer pasetoFooter) *jwt.Token {
+	header := map[string]interface{}{"alg": p.Purpose}
+	if footer.Kid != "" {
+		header["kid"] = footer.Kid
+	}
+	if footer.Usage != "" {
+		header[HeaderUsage] = footer.Usage
+	}
+	return &jwt.Token{
+		Raw:    tokenStr,
+		Method: pasetoMethod(p.Purpose),
+		Header: header,
+		Claims: jwt.MapClaims(claims),
+		Valid:  true,
+	}
+}
+
+func (p *PasetoConf) ParseTokenUnValidate(tokenStr string) (*jwt.Token, error) {
+	if p == nil {
+		return nil, errors.New("pasetoConf is nil")
+	}
+	claims, footer, err := p.open(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if footer.Usage == usageRefresh {
+		return nil, errors.New("refresh token is not an access token")
+	}
+	return p.toJwtToken(tokenStr, claims, footer), nil
+}
+
+func (p *PasetoConf) ParseToken(tokenStr string) (*jwt.Token, error) {
+	token, err := p.ParseTokenUnValidate(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if err = validatePasetoTime(token.Claims.(jwt.MapClaims)); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (p *PasetoConf) GetToken(host string, data map[string]interface{}, exp uint8) (*string, error) {
+	if p == nil {
+		return nil, errors.New("pasetoConf not set")
+	}
+	return issueToken(p, p.listeners, host, data, exp)
+}
+
+func (p *PasetoConf) sealAccess(claims map[string]interface{}) (string, error) {
+	return p.seal(claims, "")
+}
+
+func (p *PasetoConf) GetTokenWithRefresh(host string, data map[string]interface{}, exp uint8) (*token, error) {
+	if p == nil {
+		return nil, errors.New("pasetoConf not set")
+	}
+	return issueTokenWithRefresh(p, p.listeners, host, data, exp)
+}
+
+func (p *PasetoConf) GetTokenWithSession(host string, data map[string]interface{}, exp uint8, sess *Session) (*token, error) {
+	return issueWithSession(p.sessionStore, sess, data, func(claims map[string]interface{}) (*token, error) {
+		return p.GetTokenWithRefresh(host, claims, exp)
+	})
+}
+
+// refresh token 一律是 v4.local, 不論 access token 的 purpose
+func (p *PasetoConf) sealRefresh(claims map[string]interface{}) (string, error) {
+	key, err := p.refreshKey()
+	if err != nil {
+		return "", err
+	}
+	m, err := encodePasetoClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	f, err := json.Marshal(pasetoFooter{Kid: p.Kid, Usage: usageRefresh})
+	if err != nil {
+		return "", err
+	}
+	return pasetoEncrypt(key, m, f)
+}
+
+func (p *PasetoConf) ParseRefreshToken(refreshToken string) (host string, data map[string]interface{}, err error) {
+	return parseRefreshToken(p, refreshToken)
+}
+
+func (p *PasetoConf) openRefresh(refreshToken string) (map[string]interface{}, error) {
+	key, err := p.refreshKey()
+	if err != nil {
+		return nil, err
+	}
+	m, f, err := pasetoDecrypt(key, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	var footer pasetoFooter
+	if err = json.Unmarshal(f, &footer); err != nil || footer.Usage != usageRefresh {
+		return nil, errors.New("not a refresh token")
+	}
+	data, err := decodePasetoClaims(m)
+	if err != nil {
+		return nil, err
+	}
+	if err = validatePasetoTime(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (p *PasetoConf) RefreshAccessToken(refreshToken string) (*string, error) {
+	if p == nil {
+		return nil, errors.New("pasetoConf not set")
+	}
+	return refreshAccessToken(p, p.sessionStore, p.listeners, refreshToken)
+}
+
+func (p *PasetoConf) GetAccessToken(host string, source string, id interface{}, db string, perm ApiPerm) (*string, error) {
+	if p == nil {
+		return nil, errors.New("pasetoConf not set")
+	}
+	ss, err := p.seal(map[string]interface{}{
+		"iss":      host,
+		"source":   source,
+		"sourceId": id,
+		"db":       db,
+		"per":      perm,
+	}, UsageAccess)
+	if err != nil {
+		return nil, err
+	}
+	emitAccessTokenEvent(p.listeners, source, id)
+	return &ss, nil
+}
+
+type mixedTokenParser struct {
+	jwt    TokenParser
+	paseto TokenParser
+}
+
+// 依前綴分派: v4. 開頭交給 PASETO, 其餘交給 JWT, 讓服務可以逐步遷移
+func NewMixedTokenParser(jwtParser, pasetoParser TokenParser) TokenParser {
+	return &mixedTokenParser{jwt: jwtParser, paseto: pasetoParser}
+}
+
+func (m *mixedTokenParser) ParseToken(tokenStr string) (*jwt.Token, error) {
+	if IsPaseto(tokenStr) {
+		if m.paseto == nil {
+			return nil, errors.New("paseto token not accepted")
+		}
+		return m.paseto.ParseToken(tokenStr)
+	}
+	if m.jwt == nil {
+		return nil, errors.New("jwt token not accepted")
+	}
+	return m.jwt.ParseToken(tokenStr)
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestPasetoRoundTrip(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localKey := make([]byte, 32)
+	if _, err = rand.Read(localKey); err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewPasetoLocalConf("kid", localKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, p := range map[string]*PasetoConf{
+		"public": NewPasetoPublicConf("kid", priv),
+		"local":  local,
+	} {
+		t.Run(name, func(t *testing.T) {
+			p.RefreshSecret = "refresh-secret"
+			tok, err := p.GetTokenWithRefresh("host", map[string]interface{}{ClaimUserId: "u1"}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsPaseto(tok.AccessToken) {
+				t.Fatalf("not a paseto token: %s", tok.AccessToken)
+			}
+			token, err := p.ParseToken(tok.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u := NewReqUserFromToken(token); u.GetId() != "u1" || u.GetHost() != "host" || u.GetUsage() != "" {
+				t.Fatalf("user %s %s %q", u.GetId(), u.GetHost(), u.GetUsage())
+			}
+			if _, err = p.ParseToken(tok.RefreshToken); err == nil {
+				t.Fatal("refresh token accepted as access token")
+			}
+			if _, err = p.RefreshAccessToken(tok.RefreshToken); err != nil {
+				t.Fatal(err)
+			}
+
+			access, err := p.GetAccessToken("host", "svc", "id", "db", "read")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token, err = p.ParseToken(*access); err != nil {
+				t.Fatal(err)
+			}
+			if u := NewReqUserFromToken(token); u.GetUsage() != UsageAccess {
+				t.Fatalf("access usage %q", u.GetUsage())
+			}
+
+			b := []byte(tok.AccessToken)
+			b[len(pasetoPrefix)+10] ^= 1
+			if _, err = p.ParseToken(string(b)); err == nil {
+				t.Fatal("tampered token accepted")
+			}
+		})
+	}
+}
+
+func TestMixedTokenParser(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPasetoPublicConf("kid", priv)
+	j, err := NewEphemeralJwtConf("kid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := NewMixedTokenParser(j, p)
+	for _, issuer := range []JwtToken{j, p} {
+		tokenStr, err := issuer.GetToken("host", map[string]interface{}{ClaimUserId: "u1"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := parser.ParseToken(*tokenStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub := token.Claims.(jwt.MapClaims)[ClaimUserId]; sub != "u1" {
+			t.Fatalf("sub %v", sub)
+		}
+	}
+}
+
+// paseto-standard/test-vectors v4.json 中不含 implicit assertion 的成功向量與所有失敗向量
+var pasetoV4Vectors = []struct {
+	name    string
+	purpose string
+	// v4.local 為 32 bytes 金鑰, v4.public 為 Ed25519 seed
+	key     string
+	nonce   string
+	token   string
+	payload string
+	footer  string
+	fail    bool
+}{
+	{
+		name:    "4-E-1",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+		payload: "{\"data\":\"this is a secret message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+	},
+	{
+		name:    "4-E-2",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A",
+		payload: "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+	},
+	{
+		name:    "4-E-3",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+		payload: "{\"data\":\"this is a secret message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+	},
+	{
+		name:    "4-E-4",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4gt6TiLm55vIH8c_lGxxZpE3AWlH4WTR0v45nsWoU3gQ",
+		payload: "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+	},
+	{
+		name:    "4-E-5",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload: "{\"data\":\"this is a secret message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+		footer:  "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}",
+	},
+	{
+		name:    "4-E-6",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WiA8rd3wgFSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6pWSA5HX2wjb3P-xLQg5K5feUCX4P2fpVK3ZLWFbMSxQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload: "{\"data\":\"this is a hidden message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+		footer:  "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}",
+	},
+	{
+		name:    "4-S-1",
+		purpose: PasetoV4Public,
+		key:     "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774",
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+		payload: "{\"data\":\"this is a signed message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+	},
+	{
+		name:    "4-S-2",
+		purpose: PasetoV4Public,
+		key:     "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774",
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		payload: "{\"data\":\"this is a signed message\",\"exp\":\"2022-01-01T00:00:00+00:00\"}",
+		footer:  "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}",
+	},
+	{
+		name:    "4-F-1",
+		purpose: PasetoV4Public,
+		key:     "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774",
+		token:   "v4.local.vngXfCISbnKgiP6VWGuOSlYrFYU300fy9ijW33rznDYgxHNPwWluAY2Bgb0z54CUs6aYYkIJ-bOOOmJHPuX_34Agt_IPlNdGDpRdGNnBz2MpWJvB3cttheEc1uyCEYltj7wBQQYX.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24",
+		footer:  "arbitrary-string-that-isn't-json",
+		fail:    true,
+	},
+	{
+		name:    "4-F-2",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		token:   "v4.public.eyJpbnZhbGlkIjoidGhpcyBzaG91bGQgbmV2ZXIgZGVjb2RlIn22Sp4gjCaUw0c7EH84ZSm_jN_Qr41MrgLNu5LIBCzUr1pn3Z-Wukg9h3ceplWigpoHaTLcwxj0NsI1vjTh67YB.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		footer:  "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}",
+		fail:    true,
+	},
+	{
+		name:    "4-F-3",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "26f7553354482a1d91d4784627854b8da6b8042a7966523c2b404e8dbbe7f7f2",
+		token:   "v3.local.23e_2PiqpQBPvRFKzB0zHhjmxK3sKo2grFZRRLM-U7L0a8uHxuF9RlVz3Ic6WmdUUWTxCaYycwWV1yM8gKbZB2JhygDMKvHQ7eBf8GtF0r3K0Q_gF1PXOxcOgztak1eD1dPe9rLVMSgR0nHJXeIGYVuVrVoLWQ.YXJiaXRyYXJ5LXN0cmluZy10aGF0LWlzbid0LWpzb24",
+		footer:  "arbitrary-string-that-isn't-json",
+		fail:    true,
+	},
+	{
+		name:    "4-F-4",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQh",
+		fail:    true,
+	},
+	{
+		name:    "4-F-5",
+		purpose: PasetoV4Local,
+		key:     "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t4x-RMNXtQNbz7FvFZ_G-lFpk5RG3EOrwDL6CgDqcerSQ==.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+		footer:  "{\"kid\":\"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN\"}",
+		fail:    true,
+	},
+}
+
+func TestPasetoV4Vectors(t *testing.T) {
+	for _, v := range pasetoV4Vectors {
+		t.Run(v.name, func(t *testing.T) {
+			key, err := hex.DecodeString(v.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m, f []byte
+			if v.purpose == PasetoV4Local {
+				m, f, err = pasetoDecrypt(key, v.token)
+			} else {
+				m, f, err = pasetoVerify(ed25519.NewKeyFromSeed(key).Public().(ed25519.PublicKey), v.token)
+			}
+			if v.fail {
+				if err == nil {
+					t.Fatalf("token accepted: %s", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(m) != v.payload || string(f) != v.footer {
+				t.Fatalf("payload %s footer %s", m, f)
+			}
+
+			// 以相同的金鑰 (與 nonce) 產生的 token 必須與向量完全相同
+			var tokenStr string
+			if v.purpose == PasetoV4Local {
+				n, err := hex.DecodeString(v.nonce)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tokenStr, err = pasetoEncryptWithNonce(key, n, m, f); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				tokenStr = pasetoSign(ed25519.NewKeyFromSeed(key), m, f)
+			}
+			if tokenStr != v.token {
+				t.Fatalf("token %s, want %s", tokenStr, v.token)
+			}
+		})
+	}
+}
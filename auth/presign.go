@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	apierr "github.com/wayne011872/api-toolkit/errors"
+	"github.com/wayne011872/api-toolkit/mid"
+)
+
+const (
+	PresignExpiresKey     = "X-Expires"
+	PresignSignatureKey   = "X-Signature"
+	PresignSubjectKey     = "X-Subject"
+	PresignClientIPKey    = "X-Client-Ip"
+	PresignContentTypeKey = "X-Content-Type"
+
+	UsagePresigned = "presigned"
+
+	_KEY_PRESIGN_GRANT = "api_toolkit_presign_grant"
+)
+
+type PresignConstraints struct {
+	// 授權對象, 會成為 ReqUser 的 id
+	Subject string
+	// 限定使用的 client IP
+	ClientIP string
+	// 限定上傳的 Content-Type
+	ContentType string
+}
+
+type PresignGrant struct {
+	PresignConstraints
+	Method    string
+	Path      string
+	ExpiresAt time.Time
+}
+
+type Presigner interface {
+	// 回傳需附加到 URL 的 query 參數
+	Sign(method, path string, ttl time.Duration, cons PresignConstraints) (url.Values, error)
+	SignURL(method, rawURL string, ttl time.Duration, cons PresignConstraints) (string, error)
+	Verify(c *gin.Context) (*PresignGrant, error)
+}
+
+func NewPresigner(secret []byte) Presigner {
+	return &presigner{secret: secret}
+}
+
+type presigner struct {
+	secret []byte
+}
+
+func (p *presigner) mac(method, path string, expires int64, cons PresignConstraints) string {
+	h := hmac.New(sha256.New, p.secret)
+	h.Write([]byte(strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		strconv.FormatInt(expires, 10),
+		cons.Subject,
+		cons.ClientIP,
+		cons.ContentType,
+	}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (p *presigner) Sign(method, path string, ttl time.Duration, cons PresignConstraints) (url.Values, error) {
+	if len(p.secret) == 0 {
+		return nil, errors.New("presign secret not set")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("invalid ttl")
+	}
+	expires := time.Now().Add(ttl).Unix()
+	v := url.Values{}
+	v.Set(PresignExpiresKey, strconv.FormatInt(expires, 10))
+	if cons.Subject != "" {
+		v.Set(PresignSubjectKey, cons.Subject)
+	}
+	if cons.ClientIP != "" {
+		v.Set(PresignClientIPKey, cons.ClientIP)
+	}
+	if cons.ContentType != "" {
+		v.Set(PresignContentTypeKey, cons.ContentType)
+	}
+	v.Set(PresignSignatureKey, p.mac(method, path, expires, cons))
+	return v, nil
+}
+
+func (p *presigner) SignURL(method, rawURL string, ttl time.Duration, cons PresignConstraints) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	v, err := p.Sign(method, u.Path, ttl, cons)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for k := range v {
+		q.Set(k, v.Get(k))
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (p *presigner) Verify(c *gin.Context) (*PresignGrant, error) {
+	q := c.Request.URL.Query()
+	expires, err := strconv.ParseInt(q.Get(PresignExpiresKey), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid expires")
+	}
+	grant := &PresignGrant{
+		PresignConstraints: PresignConstraints{
+			Subject:     q.Get(PresignSubjectKey),
+			ClientIP:    q.Get(PresignClientIPKey),
+			ContentType: q.Get(PresignContentTypeKey),
+		},
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		ExpiresAt: time.Unix(expires, 0),
+	}
+	expected := p.mac(grant.Method, grant.Path, expires, grant.PresignConstraints)
+	if !hmac.Equal([]byte(expected), []byte(q.Get(PresignSignatureKey))) {
+		return nil, errors.New("invalid signature")
+	}
+	if time.Now().After(grant.ExpiresAt) {
+		return nil, errors.New("url expired")
+	}
+	if grant.ClientIP != "" && grant.ClientIP != c.ClientIP() {
+		return nil, errors.New("client ip not match")
+	}
+	if grant.ContentType != "" && grant.ContentType != c.ContentType() {
+		return nil, errors.New("content type not match")
+	}
+	return grant, nil
+}
+
+func GetPresignGrant(c *gin.Context) *PresignGrant {
+	data, ok := c.Get(_KEY_PRESIGN_GRANT)
+	if !ok {
+		return nil
+	}
+	return data.(*PresignGrant)
+}
+
+// 須放在 auth middleware 之前; 沒有簽章參數的請求直接交給後面的 middleware
+func NewGinPresignMid(p Presigner, listeners ...AuthEventListener) mid.GinMiddle {
+	return &presignMiddle{presigner: p, listeners: listeners}
+}
+
+type presignMiddle struct {
+	apierr.CommonApiErrorHandler
+	presigner Presigner
+	listeners AuthEventListeners
+}
+
+func (m *presignMiddle) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query(PresignSignatureKey) == "" {
+			c.Next()
+			return
+		}
+		grant, err := m.presigner.Verify(c)
+		if err != nil {
+			e := NewAuthEventFromGin(c, EventAuthFailure, apierr.Error_Auth_Presign_Invalid.Error())
+			e.Detail = err.Error()
+			m.listeners.OnAuthEvent(e)
+			m.GinApiErrorHandler(c, apierr.Error_Auth_Presign_Invalid)
+			c.Abort()
+			return
+		}
+		c.Set(_KEY_PRESIGN_GRANT, grant)
+		user := NewReqUser(getHost(c.Request), grant.Subject, "", "", nil, UsagePresigned)
+		SetReqUserToGin(c, user)
+		c.Request = c.Request.WithContext(SetReqUserToCtx(c.Request.Context(), user))
+		c.Next()
+	}
+}
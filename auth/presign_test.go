@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func presignContext(method, target string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(method, target, nil)
+	return c
+}
+
+func TestPresignRoundTrip(t *testing.T) {
+	p := NewPresigner([]byte("presign-secret"))
+	signed, err := p.SignURL(http.MethodGet, "http://api.local/files/a.txt?v=1", time.Minute, PresignConstraints{Subject: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, err := p.Verify(presignContext(http.MethodGet, signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant.Subject != "u1" || grant.Path != "/files/a.txt" || grant.Method != http.MethodGet {
+		t.Fatalf("grant %+v", grant)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set(PresignSubjectKey, "u2")
+	for name, target := range map[string]string{
+		"method":  signed,
+		"path":    "http://api.local/files/b.txt?" + u.RawQuery,
+		"subject": "http://api.local/files/a.txt?" + q.Encode(),
+	} {
+		method := http.MethodGet
+		if name == "method" {
+			method = http.MethodDelete
+		}
+		if _, err = p.Verify(presignContext(method, target)); err == nil {
+			t.Errorf("%s changed but signature accepted", name)
+		}
+	}
+	if _, err = NewPresigner([]byte("other")).Verify(presignContext(http.MethodGet, signed)); err == nil {
+		t.Error("signature of another secret accepted")
+	}
+
+	expired, err := p.Sign(http.MethodGet, "/files/a.txt", time.Nanosecond, PresignConstraints{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Verify(presignContext(http.MethodGet, "/files/a.txt?"+expired.Encode())); err == nil {
+		t.Error("expired url accepted")
+	}
+}
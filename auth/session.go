@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const ClaimSessionId = "sid"
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type Session struct {
+	Id         string    `json:"id"`
+	UserId     string    `json:"userId"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Revoked    bool      `json:"-"`
+}
+
+func NewSession(c *gin.Context, userId, device string) *Session {
+	now := time.Now()
+	return &Session{
+		UserId:     userId,
+		Device:     device,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+}
+
+type SessionStore interface {
+	Create(s *Session) error
+	// 找不到時回傳 ErrSessionNotFound
+	Get(id string) (*Session, error)
+	Touch(id string, ip string, t time.Time) error
+	List(userId string) ([]*Session, error)
+	Revoke(userId, id string) error
+	RevokeAll(userId string) error
+}
+
+// 先以複製的 data 簽發 token, 成功後才建立 session, 簽發失敗不會留下用不到的 session
+func issueWithSession(store SessionStore, sess *Session, data map[string]interface{}, issue func(claims map[string]interface{}) (*token, error)) (*token, error) {
+	if store == nil {
+		return nil, errors.New("session store not set")
+	}
+	if sess == nil || sess.UserId == "" {
+		return nil, errors.New("invalid session")
+	}
+	if data == nil {
+		return nil, errors.New("no data")
+	}
+	if claimString(data, ClaimUserId) != sess.UserId {
+		return nil, errors.New("session user not match")
+	}
+	id := sess.Id
+	if id == "" {
+		var err error
+		if id, err = newTokenId(); err != nil {
+			return nil, err
+		}
+	}
+	claims := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		claims[k] = v
+	}
+	claims[ClaimSessionId] = id
+	t, err := issue(claims)
+	if err != nil {
+		return nil, err
+	}
+	sess.Id = id
+	if err = store.Create(sess); err != nil {
+		return nil, err
+	}
+	t.SessionId = id
+	return t, nil
+}
+
+func NewMemSessionStore() SessionStore {
+	return &memSessionStore{
+		data: make(map[string]*Session),
+	}
+}
+
+// 與 refresh token 的效期相同, 閒置超過的 session 已無法再使用
+const memSessionIdleTTL = 24 * time.Hour
+
+type memSessionStore struct {
+	lock      sync.RWMutex
+	data      map[string]*Session
+	lastClean time.Time
+}
+
+// 移除已撤銷與閒置過久的 session, 之後 refresh 會得到 ErrSessionNotFound
+func (s *memSessionStore) cleanExpired(now time.Time) {
+	if now.Sub(s.lastClean) < time.Minute {
+		return
+	}
+	s.lastClean = now
+	for k, v := range s.data {
+		if v.Revoked || now.Sub(v.LastSeenAt) > memSessionIdleTTL {
+			delete(s.data, k)
+		}
+	}
+}
+
+func (s *memSessionStore) Create(sess *Session) error {
+	if sess.Id == "" {
+		id, err := newTokenId()
+		if err != nil {
+			return err
+		}
+		sess.Id = id
+	}
+	now := time.Now()
+	if sess.CreatedAt.IsZero() {
+		sess.CreatedAt = now
+	}
+	if sess.LastSeenAt.IsZero() {
+		sess.LastSeenAt = now
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cleanExpired(now)
+	cp := *sess
+	s.data[sess.Id] = &cp
+	return nil
+}
+
+func (s *memSessionStore) Get(id string) (*Session, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	sess, ok := s.data[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	cp := *sess
+	return &cp, nil
+}
+
+func (s *memSessionStore) Touch(id string, ip string, t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sess, ok := s.data[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	sess.LastSeenAt = t
+	if ip != "" {
+		sess.IP = ip
+	}
+	return nil
+}
+
+func (s *memSessionStore) List(userId string) ([]*Session, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var result []*Session
+	for _, sess := range s.data {
+		if sess.UserId == userId && !sess.Revoked {
+			cp := *sess
+			result = append(result, &cp)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeenAt.After(result[j].LastSeenAt)
+	})
+	return result, nil
+}
+
+func (s *memSessionStore) Revoke(userId, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sess, ok := s.data[id]
+	if !ok || sess.UserId != userId {
+		return ErrSessionNotFound
+	}
+	sess.Revoked = true
+	return nil
+}
+
+func (s *memSessionStore) RevokeAll(userId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sess := range s.data {
+		if sess.UserId == userId {
+			sess.Revoked = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	apierr "github.com/wayne011872/api-toolkit/errors"
+)
+
+func TestGetTokenWithSessionUserMismatch(t *testing.T) {
+	j := newFileJwtConf(t)
+	store := NewMemSessionStore()
+	j.SetSessionStore(store)
+
+	sess := &Session{UserId: "u1"}
+	if _, err := j.GetTokenWithSession("host", map[string]interface{}{ClaimUserId: "u2"}, 10, sess); err == nil {
+		t.Fatal("session of another user accepted")
+	}
+	if list, _ := store.List("u1"); len(list) != 0 {
+		t.Fatalf("session created for a rejected token: %+v", list)
+	}
+
+	data := map[string]interface{}{ClaimUserId: "u1"}
+	tok, err := j.GetTokenWithSession("host", data, 10, sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := data[ClaimSessionId]; ok {
+		t.Fatal("caller data modified")
+	}
+	if _, err = j.RefreshAccessToken(tok.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemSessionStorePrunes(t *testing.T) {
+	store := NewMemSessionStore().(*memSessionStore)
+	now := time.Now()
+	for _, sess := range []*Session{
+		{Id: "revoked", UserId: "u1"},
+		{Id: "idle", UserId: "u1", LastSeenAt: now.Add(-memSessionIdleTTL - time.Minute)},
+		{Id: "active", UserId: "u1"},
+	} {
+		if err := store.Create(sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Revoke("u1", "revoked"); err != nil {
+		t.Fatal(err)
+	}
+
+	store.lastClean = time.Time{}
+	if err := store.Create(&Session{Id: "new", UserId: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"revoked", "idle"} {
+		if _, err := store.Get(id); err != ErrSessionNotFound {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+	for _, id := range []string{"active", "new"} {
+		if _, err := store.Get(id); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+}
+
+func TestGetTokenWithSessionSignFailure(t *testing.T) {
+	j := newFileJwtConf(t)
+	j.RefreshSecret = ""
+	store := NewMemSessionStore()
+	j.SetSessionStore(store)
+	if _, err := j.GetTokenWithSession("host", map[string]interface{}{ClaimUserId: "u1"}, 10, &Session{UserId: "u1"}); err == nil {
+		t.Fatal("token issued without a refresh secret")
+	}
+	if list, _ := store.List("u1"); len(list) != 0 {
+		t.Fatalf("orphaned session: %+v", list)
+	}
+}
+
+func TestRefreshRevokedSessionEvent(t *testing.T) {
+	j := newFileJwtConf(t)
+	store := NewMemSessionStore()
+	j.SetSessionStore(store)
+	rec := &eventRecorder{}
+	j.AddEventListener(rec)
+
+	tok, err := j.GetTokenWithSession("host", map[string]interface{}{ClaimUserId: "u1"}, 10, &Session{UserId: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Revoke("u1", tok.SessionId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = j.RefreshAccessToken(tok.RefreshToken); err == nil {
+		t.Fatal("refresh of a revoked session accepted")
+	}
+	e := rec.last()
+	if e == nil || e.Type != EventAuthFailure {
+		t.Fatalf("event %+v", e)
+	}
+	// Reason 作為 metrics label, 不可帶入實際錯誤
+	if e.Reason != apierr.Error_Auth_Session_Invalid.Error() || e.Detail == "" || e.Detail == e.Reason {
+		t.Fatalf("reason %q detail %q", e.Reason, e.Detail)
+	}
+}
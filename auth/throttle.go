@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/errors"
+	"github.com/wayne011872/api-toolkit/mid"
+)
+
+type ThrottleRecord struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	// Check 保留、尚未回報結果的嘗試, 視同失敗計算; ReservedUntil 之後失效
+	Reserved      int
+	ReservedUntil time.Time
+	LastAttempt   time.Time
+}
+
+// Reserve、RecordFailure、Release 必須是原子操作, 實作可在鎖或交易內呼叫 ThrottleConf 與 ThrottleRecord 的同名方法
+type ThrottleStore interface {
+	Get(key string) (*ThrottleRecord, error)
+	Delete(key string) error
+	// 允許嘗試時保留一次並回傳 true, 否則不變更紀錄; 回傳更新後的紀錄
+	Reserve(key string, now time.Time, conf ThrottleConf) (*ThrottleRecord, bool, error)
+	// 記錄一次失敗並釋放一次保留, 回傳更新後的紀錄
+	RecordFailure(key string, now time.Time, conf ThrottleConf) (*ThrottleRecord, error)
+	// 釋放一次保留, 用於成功或放棄的嘗試
+	Release(key string, now time.Time) error
+}
+
+type ThrottleConf struct {
+	// 不延遲的失敗次數
+	FreeAttempts int
+	// 超過 FreeAttempts 後每次失敗延遲加倍, 最多 MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// 連續失敗達此次數即鎖定 LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// 超過此時間沒有失敗則重新計算
+	Window time.Duration
+	// Check 保留的嘗試在此時間內沒有呼叫 Success 或 Failure 即失效, 預設 1 分鐘
+	ReserveTimeout time.Duration
+}
+
+func (c ThrottleConf) withDefault() ThrottleConf {
+	if c.FreeAttempts <= 0 {
+		c.FreeAttempts = 3
+	}
+	if c.BaseDelay <= 0 {
+		c.BaseDelay = time.Second
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = time.Minute
+	}
+	if c.LockoutThreshold <= 0 {
+		c.LockoutThreshold = 10
+	}
+	if c.LockoutDuration <= 0 {
+		c.LockoutDuration = 15 * time.Minute
+	}
+	if c.Window <= 0 {
+		c.Window = time.Hour
+	}
+	if c.ReserveTimeout <= 0 {
+		c.ReserveTimeout = time.Minute
+	}
+	return c
+}
+
+// 紀錄保存的時間
+func (c ThrottleConf) TTL() time.Duration {
+	ttl := c.Window
+	if c.LockoutDuration > ttl {
+		ttl = c.LockoutDuration
+	}
+	if c.ReserveTimeout > ttl {
+		ttl = c.ReserveTimeout
+	}
+	return ttl
+}
+
+// 回傳下次允許嘗試的時間, 保留中的嘗試視同失敗
+func (c ThrottleConf) NextAllowed(r *ThrottleRecord, now time.Time) time.Time {
+	if r.LockedUntil.After(now) {
+		return r.LockedUntil
+	}
+	failures, last := 0, r.LastFailure
+	if now.Sub(r.LastFailure) <= c.Window {
+		failures = r.Failures
+	}
+	if r.ReservedUntil.After(now) {
+		failures += r.Reserved
+		if r.LastAttempt.After(last) {
+			last = r.LastAttempt
+		}
+	}
+	over := failures - c.FreeAttempts
+	if over <= 0 {
+		return time.Time{}
+	}
+	delay := time.Duration(float64(c.BaseDelay) * math.Pow(2, float64(over-1)))
+	if delay > c.MaxDelay || delay <= 0 {
+		delay = c.MaxDelay
+	}
+	return last.Add(delay)
+}
+
+// 以下修改 r, 由 ThrottleStore 在原子操作內呼叫
+func (c ThrottleConf) Reserve(r *ThrottleRecord, now time.Time) bool {
+	if c.NextAllowed(r, now).After(now) {
+		return false
+	}
+	if !r.ReservedUntil.After(now) {
+		r.Reserved = 0
+	}
+	r.Reserved++
+	r.ReservedUntil = now.Add(c.ReserveTimeout)
+	r.LastAttempt = now
+	return true
+}
+
+func (c ThrottleConf) RecordFailure(r *ThrottleRecord, now time.Time) {
+	if now.Sub(r.LastFailure) > c.Window {
+		r.Failures = 0
+	}
+	r.Release(now)
+	r.Failures++
+	r.LastFailure = now
+	if r.Failures >= c.LockoutThreshold {
+		r.LockedUntil = now.Add(c.LockoutDuration)
+	}
+}
+
+// 釋放一次保留, 由 ThrottleStore.Release 在原子操作內呼叫
+func (r *ThrottleRecord) Release(now time.Time) {
+	if r.Reserved > 0 && r.ReservedUntil.After(now) {
+		r.Reserved--
+	} else {
+		r.Reserved = 0
+	}
+}
+
+// 登入處理前呼叫 Check, 驗證帳密/OTP 後依結果呼叫 Success 或 Failure
+// Check 通過時會保留一次嘗試, 並行的請求不會同時取得同一個名額
+type LoginThrottle interface {
+	Check(c *gin.Context, account string) error
+	Success(c *gin.Context, account string) error
+	// 失敗次數達到 LockoutThreshold 而鎖定時發出 EventLockout, Detail 為被鎖定的 key
+	Failure(c *gin.Context, account string) error
+	AddEventListener(listeners ...AuthEventListener)
+}
+
+func NewLoginThrottle(conf ThrottleConf, store ThrottleStore) LoginThrottle {
+	return &loginThrottle{
+		conf:  conf.withDefault(),
+		store: store,
+	}
+}
+
+type loginThrottle struct {
+	conf      ThrottleConf
+	store     ThrottleStore
+	listeners AuthEventListeners
+}
+
+func (t *loginThrottle) AddEventListener(listeners ...AuthEventListener) {
+	t.listeners = append(t.listeners, listeners...)
+}
+
+const _KEY_THROTTLE_RESERVED = "api_toolkit_throttle_reserved"
+
+func throttleKeys(c *gin.Context, account string) []string {
+	keys := []string{"ip:" + c.ClientIP()}
+	if account != "" {
+		keys = append(keys, "acc:"+account)
+	}
+	return keys
+}
+
+// 同一個請求中已保留的 key, middleware 與 handler 都呼叫 Check 時不重複保留
+func reservedKeys(c *gin.Context) map[string]bool {
+	if v, ok := c.Get(_KEY_THROTTLE_RESERVED); ok {
+		return v.(map[string]bool)
+	}
+	keys := map[string]bool{}
+	c.Set(_KEY_THROTTLE_RESERVED, keys)
+	return keys
+}
+
+func (t *loginThrottle) Check(c *gin.Context, account string) error {
+	now := time.Now()
+	reserved := reservedKeys(c)
+	var taken []string
+	var wait time.Duration
+	denied, locked := false, false
+	for _, key := range throttleKeys(c, account) {
+		if reserved[key] {
+			continue
+		}
+		r, ok, err := t.store.Reserve(key, now, t.conf)
+		if err != nil {
+			t.release(taken, now)
+			return err
+		}
+		if ok {
+			taken = append(taken, key)
+			continue
+		}
+		denied = true
+		if d := t.conf.NextAllowed(r, now).Sub(now); d > wait {
+			wait = d
+			locked = r.LockedUntil.After(now)
+		}
+	}
+	if !denied {
+		for _, key := range taken {
+			reserved[key] = true
+		}
+		return nil
+	}
+	// 被拒絕的請求不佔用其他 key 的名額
+	if err := t.release(taken, now); err != nil {
+		return err
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	if locked {
+		return errors.Error_Auth_Locked
+	}
+	return errors.Error_Auth_Too_Many_Attempts
+}
+
+func (t *loginThrottle) release(keys []string, now time.Time) error {
+	for _, key := range keys {
+		if err := t.store.Release(key, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *loginThrottle) Failure(c *gin.Context, account string) error {
+	now := time.Now()
+	reserved := reservedKeys(c)
+	for _, key := range throttleKeys(c, account) {
+		r, err := t.store.RecordFailure(key, now, t.conf)
+		if err != nil {
+			return err
+		}
+		delete(reserved, key)
+		if r.Failures >= t.conf.LockoutThreshold {
+			e := NewAuthEventFromGin(c, EventLockout, errors.Error_Auth_Locked.Error())
+			e.Account = account
+			e.Detail = key
+			t.listeners.OnAuthEvent(e)
+		}
+	}
+	return nil
+}
+
+// 成功只清除帳號紀錄, 同一 IP 對其他帳號的猜測仍然計算
+func (t *loginThrottle) Success(c *gin.Context, account string) error {
+	now := time.Now()
+	reserved := reservedKeys(c)
+	for _, key := range throttleKeys(c, account) {
+		if key == "acc:"+account {
+			if err := t.store.Delete(key); err != nil {
+				return err
+			}
+		} else if reserved[key] {
+			if err := t.store.Release(key, now); err != nil {
+				return err
+			}
+		}
+		delete(reserved, key)
+	}
+	return nil
+}
+
+// 只以 IP 檢查, 用於登入路由在進入 handler 前先擋下
+func NewGinThrottleMid(t LoginThrottle) mid.GinMiddle {
+	return &throttleMiddle{throttle: t}
+}
+
+type throttleMiddle struct {
+	errors.CommonApiErrorHandler
+	throttle LoginThrottle
+}
+
+func (m *throttleMiddle) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := m.throttle.Check(c, ""); err != nil {
+			m.GinApiErrorHandler(c, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func NewMemThrottleStore() ThrottleStore {
+	return &memThrottleStore{
+		data: make(map[string]*memThrottleItem),
+	}
+}
+
+type memThrottleItem struct {
+	record   ThrottleRecord
+	expireAt time.Time
+}
+
+type memThrottleStore struct {
+	lock      sync.Mutex
+	data      map[string]*memThrottleItem
+	lastClean time.Time
+}
+
+func (s *memThrottleStore) cleanExpired(now time.Time) {
+	if now.Sub(s.lastClean) < time.Minute {
+		return
+	}
+	s.lastClean = now
+	for k, v := range s.data {
+		if now.After(v.expireAt) {
+			delete(s.data, k)
+		}
+	}
+}
+
+func (s *memThrottleStore) Get(key string) (*ThrottleRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.cleanExpired(now)
+	item, ok := s.data[key]
+	if !ok || now.After(item.expireAt) {
+		return nil, nil
+	}
+	r := item.record
+	return &r, nil
+}
+
+// 在鎖內取得或建立紀錄, fn 修改後依 ttl 存回
+func (s *memThrottleStore) update(key string, ttl time.Duration, fn func(r *ThrottleRecord)) *ThrottleRecord {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.cleanExpired(now)
+	item, ok := s.data[key]
+	if !ok || now.After(item.expireAt) {
+		item = &memThrottleItem{}
+		s.data[key] = item
+	}
+	fn(&item.record)
+	item.expireAt = now.Add(ttl)
+	r := item.record
+	return &r
+}
+
+func (s *memThrottleStore) Reserve(key string, now time.Time, conf ThrottleConf) (*ThrottleRecord, bool, error) {
+	ok := false
+	r := s.update(key, conf.TTL(), func(r *ThrottleRecord) {
+		ok = conf.Reserve(r, now)
+	})
+	return r, ok, nil
+}
+
+func (s *memThrottleStore) RecordFailure(key string, now time.Time, conf ThrottleConf) (*ThrottleRecord, error) {
+	return s.update(key, conf.TTL(), func(r *ThrottleRecord) {
+		conf.RecordFailure(r, now)
+	}), nil
+}
+
+func (s *memThrottleStore) Release(key string, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if item, ok := s.data[key]; ok {
+		item.record.Release(now)
+	}
+	return nil
+}
+
+func (s *memThrottleStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data, key)
+	return nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+func throttleContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/login", nil)
+	return c
+}
+
+func TestThrottleCheckReservesAttempts(t *testing.T) {
+	conf := ThrottleConf{FreeAttempts: 3, BaseDelay: time.Minute}
+	th := NewLoginThrottle(conf, NewMemThrottleStore())
+	var passed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if th.Check(throttleContext(), "amy") == nil {
+				atomic.AddInt32(&passed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	// 保留中的嘗試視同失敗, 第 FreeAttempts+1 次之後開始延遲
+	if passed != int32(conf.FreeAttempts+1) {
+		t.Fatalf("%d concurrent checks passed", passed)
+	}
+}
+
+func TestThrottleSuccessReleases(t *testing.T) {
+	th := NewLoginThrottle(ThrottleConf{FreeAttempts: 1, BaseDelay: time.Minute}, NewMemThrottleStore())
+	for i := 0; i < 10; i++ {
+		c := throttleContext()
+		if err := th.Check(c, ""); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		// middleware 已保留 IP, handler 再次 Check 不重複保留
+		if err := th.Check(c, "amy"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if err := th.Success(c, "amy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestThrottleLockout(t *testing.T) {
+	th := NewLoginThrottle(ThrottleConf{FreeAttempts: 10, LockoutThreshold: 3}, NewMemThrottleStore())
+	for i := 0; i < 3; i++ {
+		c := throttleContext()
+		if err := th.Check(c, "amy"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if err := th.Failure(c, "amy"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := throttleContext()
+	if err := th.Check(c, "amy"); err != errors.Error_Auth_Locked {
+		t.Fatalf("Check = %v", err)
+	}
+	if c.Writer.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After")
+	}
+}
@@ -19,9 +19,34 @@ func AutoGinApiServer(cfg *Config) (*http.Server, error) {
 	if cfg.authMid != nil {
 		server = server.SetAuth(cfg.authMid)
 	}
-	server = server.Middles(cfg.getMiddles()...).
+	if len(cfg.perms) > 0 {
+		server = server.SetPerms(cfg.perms...)
+	}
+	if cfg.openapi != nil {
+		server = server.ServeOpenAPI(*cfg.openapi)
+	}
+	if cfg.docs != nil {
+		server = server.ServeDocs(*cfg.docs)
+	}
+	server = server.PreAuthMiddles(cfg.getPreAuthMiddles()...).
+		Middles(cfg.middles...)
+	if cfg.validate != nil {
+		conf := *cfg.validate
+		if conf.Logger == nil {
+			conf.Logger = cfg.Logger
+		}
+		if conf.Response == nil && cfg.Debug {
+			response := true
+			conf.Response = &response
+		}
+		server = server.Middles(NewGinValidateMid(server, conf))
+	}
+	server = server.
 		AddAPIs(cfg.apis...).
 		SetTrustedProxies(cfg.TrustedProxies)
+	if err := server.Err(); err != nil {
+		return nil, err
+	}
 
 	if len(cfg.proms) > 0 {
 		server = server.SetPromhttp(cfg.proms...)
@@ -35,7 +60,7 @@ func AutoGinApiServer(cfg *Config) (*http.Server, error) {
 		cfg.Logger.Infof("run api at port: [%d], auth mode: [%s]",
 			cfg.ApiPort, authMode)
 	}
-	return server.GetServer(cfg.ApiPort), nil
+	return server.GetServerE(cfg.ApiPort)
 }
 
 func AutoGinApiRun(ctx context.Context, cfg *Config) error {
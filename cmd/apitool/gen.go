@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wayne011872/api-toolkit/codegen"
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+func runGenGo(args []string) error {
+	fs := flag.NewFlagSet("gen go", flag.ExitOnError)
+	spec := fs.String("spec", "openapi.json", "OpenAPI document file or http(s) URL")
+	pkg := fs.String("pkg", "client", "package name of the generated client")
+	out := fs.String("out", "", "output file, defaults to stdout")
+	fs.Parse(args)
+
+	doc, err := loadSpec(*spec)
+	if err != nil {
+		return err
+	}
+	src, err := codegen.GoClient(doc, codegen.GoConf{Package: *pkg})
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, src)
+}
+
+func runGenTs(args []string) error {
+	fs := flag.NewFlagSet("gen ts", flag.ExitOnError)
+	spec := fs.String("spec", "openapi.json", "OpenAPI document file or http(s) URL")
+	out := fs.String("out", "", "output file, defaults to stdout")
+	fs.Parse(args)
+
+	doc, err := loadSpec(*spec)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, codegen.TypeScriptClient(doc))
+}
+
+func loadSpec(spec string) (*openapi.Document, error) {
+	var b []byte
+	var err error
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		var resp *http.Response
+		resp, err = http.Get(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("get %s: %s", spec, resp.Status)
+		}
+		b, err = io.ReadAll(resp.Body)
+	} else {
+		b, err = os.ReadFile(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc := &openapi.Document{}
+	if err = json.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", spec, err)
+	}
+	return doc, nil
+}
+
+func writeOutput(out string, src []byte) error {
+	if out == "" {
+		_, err := os.Stdout.Write(src)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", out)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wayne011872/api-toolkit/auth"
+)
+
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keyType := fs.String("type", "rsa", "key type: rsa, ec or ed25519")
+	bits := fs.Int("bits", 2048, "rsa key size")
+	curveName := fs.String("curve", "P-256", "ec curve: P-256, P-384 or P-521")
+	kid := fs.String("kid", "", "key id, defaults to the JWK thumbprint")
+	out := fs.String("out", "jwt", "output file prefix, writes <out>.key and <out>.pub")
+	fs.Parse(args)
+
+	var priv crypto.Signer
+	var err error
+	switch *keyType {
+	case "rsa":
+		priv, err = rsa.GenerateKey(rand.Reader, *bits)
+	case "ec":
+		var curve elliptic.Curve
+		switch *curveName {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return fmt.Errorf("unsupported curve: %s", *curveName)
+		}
+		priv, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case "ed25519":
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return fmt.Errorf("unsupported key type: %s", *keyType)
+	}
+	if err != nil {
+		return err
+	}
+
+	privDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return err
+	}
+	if *kid == "" {
+		if *kid, err = auth.JwkThumbprint(priv.Public()); err != nil {
+			sum := sha256.Sum256(pubDer)
+			*kid = base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+	}
+
+	if err = writePEM(*out+".key", "PRIVATE KEY", privDer, 0600); err != nil {
+		return err
+	}
+	if err = writePEM(*out+".pub", "PUBLIC KEY", pubDer, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("kid:         %s\n", *kid)
+	fmt.Printf("private key: %s.key\n", *out)
+	fmt.Printf("public key:  %s.pub\n", *out)
+	return nil
+}
+
+func writePEM(path, typ string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), perm)
+}
@@ -0,0 +1,76 @@
+// apitool 產生金鑰、簽發與檢查 token、管理 TOTP、產生 API client 的命令列工具
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: apitool <command> [flags]
+
+commands:
+  keygen          generate RSA/EC/Ed25519 key pair
+  token issue     issue a test token from a JwtConf yaml file
+  token decode    decode a token and verify it against a JwtConf yaml file
+  refresh decrypt decrypt a refresh token
+  totp enroll     show TOTP info and write the QR code to a PNG file
+  totp verify     verify a TOTP code
+  gen go          generate a typed Go client from an OpenAPI document
+  gen ts          generate TypeScript types and a fetch client from an OpenAPI document
+
+run "apitool <command> -h" for command flags
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "keygen":
+		err = runKeygen(args)
+	case "token":
+		err = runSub(args, map[string]func([]string) error{
+			"issue":  runTokenIssue,
+			"decode": runTokenDecode,
+		})
+	case "refresh":
+		err = runSub(args, map[string]func([]string) error{
+			"decrypt": runRefreshDecrypt,
+		})
+	case "totp":
+		err = runSub(args, map[string]func([]string) error{
+			"enroll": runTotpEnroll,
+			"verify": runTotpVerify,
+		})
+	case "gen":
+		err = runSub(args, map[string]func([]string) error{
+			"go": runGenGo,
+			"ts": runGenTs,
+		})
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func runSub(args []string, cmds map[string]func([]string) error) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	return cmd(args[1:])
+}
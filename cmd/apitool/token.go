@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/wayne011872/api-toolkit/auth"
+	"gopkg.in/yaml.v3"
+)
+
+type claimFlags map[string]interface{}
+
+func (c claimFlags) String() string {
+	b, _ := json.Marshal(map[string]interface{}(c))
+	return string(b)
+}
+
+// key=value, value 可以是 JSON, 否則視為字串
+func (c claimFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("claim must be key=value: %s", s)
+	}
+	var val interface{}
+	if err := json.Unmarshal([]byte(v), &val); err != nil {
+		val = v
+	}
+	c[k] = val
+	return nil
+}
+
+func loadJwtConf(path string) (*auth.JwtConf, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing -conf")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := &auth.JwtConf{}
+	if err = yaml.Unmarshal(data, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func tokenArg(fs *flag.FlagSet) (string, error) {
+	if fs.NArg() == 0 {
+		return "", fmt.Errorf("missing token")
+	}
+	tokenStr := strings.TrimSpace(fs.Arg(0))
+	if tokenStr == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		tokenStr = strings.TrimSpace(string(b))
+	}
+	return strings.TrimPrefix(tokenStr, "Bearer "), nil
+}
+
+func runTokenIssue(args []string) error {
+	fs := flag.NewFlagSet("token issue", flag.ExitOnError)
+	confPath := fs.String("conf", "", "JwtConf yaml file")
+	host := fs.String("host", "localhost", "issuer host")
+	exp := fs.Uint("exp", 60, "expire minutes (1-180)")
+	claims := claimFlags{}
+	fs.Var(claims, "claim", "claim key=value, repeatable; JSON values are decoded")
+	fs.Parse(args)
+
+	conf, err := loadJwtConf(*confPath)
+	if err != nil {
+		return err
+	}
+	if *exp > 180 {
+		return fmt.Errorf("exp must not exceed 180 minutes")
+	}
+	tokenStr, err := conf.GetToken(*host, claims, uint8(*exp))
+	if err != nil {
+		return err
+	}
+	fmt.Println(*tokenStr)
+	return nil
+}
+
+func runTokenDecode(args []string) error {
+	fs := flag.NewFlagSet("token decode", flag.ExitOnError)
+	confPath := fs.String("conf", "", "JwtConf yaml file, skip verification when empty")
+	fs.Parse(args)
+	tokenStr, err := tokenArg(fs)
+	if err != nil {
+		return err
+	}
+
+	var conf *auth.JwtConf
+	if *confPath != "" {
+		if conf, err = loadJwtConf(*confPath); err != nil {
+			return err
+		}
+	}
+	signed := tokenStr
+	if strings.Count(tokenStr, ".") == 4 {
+		if conf == nil {
+			return fmt.Errorf("token is encrypted (JWE), -conf is required")
+		}
+		if signed, err = conf.DecryptToken(tokenStr); err != nil {
+			return fmt.Errorf("decrypt failed: %w", err)
+		}
+		fmt.Println("encrypted: yes")
+	}
+
+	// jwt-go 沒有註冊 EdDSA, 只顯示內容時忽略 alg 無法對應的錯誤
+	token, _, err := new(jwt.Parser).ParseUnverified(signed, jwt.MapClaims{})
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors == jwt.ValidationErrorUnverifiable {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("malformed token: %w", err)
+	}
+	fmt.Println("header:")
+	printJSON(token.Header)
+	fmt.Println("claims:")
+	printJSON(token.Claims)
+
+	claims := token.Claims.(jwt.MapClaims)
+	now := time.Now()
+	for _, k := range []string{"iat", "nbf", "exp"} {
+		if v, ok := claims[k].(float64); ok {
+			t := time.Unix(int64(v), 0)
+			fmt.Printf("%s: %s (%s)\n", k, t.Format(time.RFC3339), relTime(now, t))
+		}
+	}
+
+	if conf == nil {
+		fmt.Println("verify: skipped (no -conf)")
+		return nil
+	}
+	if _, err = conf.ParseToken(tokenStr); err == nil {
+		fmt.Println("verify: ok")
+		return nil
+	}
+	fmt.Println("verify: failed")
+	for _, reason := range explainFailure(conf, token, now) {
+		fmt.Println("  -", reason)
+	}
+	fmt.Println("  - parser:", err)
+	return fmt.Errorf("token invalid")
+}
+
+func explainFailure(conf *auth.JwtConf, token *jwt.Token, now time.Time) []string {
+	var reasons []string
+	if kid, _ := token.Header["kid"].(string); kid != conf.GetKid() {
+		reasons = append(reasons, fmt.Sprintf("kid %q does not match configured kid %q", kid, conf.GetKid()))
+	}
+	if alg, err := conf.SigningAlg(); err != nil {
+		reasons = append(reasons, fmt.Sprintf("load key failed: %v", err))
+	} else if tokenAlg, _ := token.Header["alg"].(string); tokenAlg != alg {
+		reasons = append(reasons, fmt.Sprintf("alg %s is not %s", tokenAlg, alg))
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if !claims.VerifyExpiresAt(now.Unix(), false) {
+		reasons = append(reasons, "token expired")
+	}
+	if !claims.VerifyNotBefore(now.Unix(), false) {
+		reasons = append(reasons, "token not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Unix(), false) {
+		reasons = append(reasons, "token issued in the future")
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "signature does not match the configured public key")
+	}
+	return reasons
+}
+
+func relTime(now, t time.Time) string {
+	d := t.Sub(now).Round(time.Second)
+	if d < 0 {
+		return (-d).String() + " ago"
+	}
+	return "in " + d.String()
+}
+
+func runRefreshDecrypt(args []string) error {
+	fs := flag.NewFlagSet("refresh decrypt", flag.ExitOnError)
+	confPath := fs.String("conf", "", "JwtConf yaml file with refresh_secret")
+	fs.Parse(args)
+	tokenStr, err := tokenArg(fs)
+	if err != nil {
+		return err
+	}
+	conf, err := loadJwtConf(*confPath)
+	if err != nil {
+		return err
+	}
+	host, data, err := conf.ParseRefreshToken(tokenStr)
+	if err != nil {
+		return err
+	}
+	fmt.Println("host:", host)
+	fmt.Println("claims:")
+	printJSON(data)
+	return nil
+}
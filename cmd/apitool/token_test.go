@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 執行 fn 並回傳其寫到 stdout 的內容
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	return string(<-done), err
+}
+
+func TestKeygenTokenIssueDecode(t *testing.T) {
+	for _, keyType := range []string{"rsa", "ec", "ed25519"} {
+		t.Run(keyType, func(t *testing.T) {
+			dir := t.TempDir()
+			prefix := filepath.Join(dir, "jwt")
+			out, err := captureStdout(t, func() error {
+				return runKeygen([]string{"-type", keyType, "-kid", "test-kid", "-out", prefix})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, "test-kid") {
+				t.Fatalf("keygen output: %s", out)
+			}
+			conf := filepath.Join(dir, "jwt.yml")
+			yml := "privatekey: " + prefix + ".key\npublickey: " + prefix + ".pub\nheader:\n  kid: test-kid\n"
+			if err = os.WriteFile(conf, []byte(yml), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			tokenStr, err := captureStdout(t, func() error {
+				return runTokenIssue([]string{"-conf", conf, "-claim", "uid=u1", "-claim", `perm=["admin"]`})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err = captureStdout(t, func() error {
+				return runTokenDecode([]string{"-conf", conf, strings.TrimSpace(tokenStr)})
+			})
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			for _, want := range []string{"verify: ok", `"uid": "u1"`, `"kid": "test-kid"`} {
+				if !strings.Contains(out, want) {
+					t.Errorf("decode output missing %q:\n%s", want, out)
+				}
+			}
+
+			// 另一組金鑰簽的 token 不可通過驗證
+			other := filepath.Join(dir, "other")
+			if _, err = captureStdout(t, func() error {
+				return runKeygen([]string{"-type", keyType, "-out", other})
+			}); err != nil {
+				t.Fatal(err)
+			}
+			yml = strings.ReplaceAll(yml, prefix, other)
+			if err = os.WriteFile(conf, []byte(yml), 0600); err != nil {
+				t.Fatal(err)
+			}
+			out, err = captureStdout(t, func() error {
+				return runTokenDecode([]string{"-conf", conf, strings.TrimSpace(tokenStr)})
+			})
+			if err == nil || !bytes.Contains([]byte(out), []byte("verify: failed")) {
+				t.Fatalf("token of another key verified: %v\n%s", err, out)
+			}
+		})
+	}
+}
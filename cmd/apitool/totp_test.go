@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/wayne011872/api-toolkit/auth"
+)
+
+func TestTotpEnrollVerify(t *testing.T) {
+	out, err := captureStdout(t, func() error {
+		return runTotpEnroll([]string{"-host", "example.com", "-account", "alice"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, rest, _ := strings.Cut(out, "\n")
+	secret := strings.TrimPrefix(first, "generated secret: ")
+	info := &auth.OtpInfo{}
+	if err = json.Unmarshal([]byte(rest), info); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	// authenticator 使用的 secret 必須與產生的 secret 相同, 不可重複 base32 編碼
+	if info.Secret != secret {
+		t.Fatalf("authenticator secret %q, generated %q", info.Secret, secret)
+	}
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-host", "example.com", "-account", "alice", "-secret", secret}
+	if _, err = captureStdout(t, func() error {
+		return runTotpVerify(append(args, "-code", code))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wrong, err := totp.GenerateCode(secret, time.Now().Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = captureStdout(t, func() error {
+		return runTotpVerify(append(args, "-code", wrong))
+	}); err == nil {
+		t.Fatal("wrong code accepted")
+	}
+}
@@ -0,0 +1,468 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+type GoConf struct {
+	// 預設 client
+	Package string
+}
+
+// 產生單一 Go 檔案: components 的型別、每個 operation 的請求 struct 與 Client 方法
+// 錯誤回應解成 *ApiError, 實作 errors.ApiError 的方法但不依賴此套件
+func GoClient(doc *openapi.Document, conf GoConf) ([]byte, error) {
+	if conf.Package == "" {
+		conf.Package = "client"
+	}
+	g := &goGen{
+		doc:   doc,
+		names: nameSet{},
+		types: map[string]string{},
+	}
+	for _, n := range goReserved {
+		g.names[n] = true
+	}
+	g.file(conf.Package)
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+var goReserved = []string{"Client", "Option", "New", "WithHTTPClient", "WithToken", "WithTokenSource", "WithHeader", "ApiError", "FieldError"}
+
+type goGen struct {
+	doc   *openapi.Document
+	buf   bytes.Buffer
+	names nameSet
+	// component 名稱對應的 Go 型別名稱
+	types map[string]string
+}
+
+func (g *goGen) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *goGen) file(pkg string) {
+	g.p("// Code generated by apitool gen go. DO NOT EDIT.")
+	g.p("")
+	g.p("// Package %s 是 %s %s 的 API client", pkg, g.doc.Info.Title, g.doc.Info.Version)
+	g.p("package %s", pkg)
+	g.p("")
+	g.p("%s", goRuntime)
+
+	var schemas map[string]*openapi.Schema
+	if g.doc.Components != nil {
+		schemas = g.doc.Components.Schemas
+	}
+	names := sortedKeys(schemas)
+	for _, name := range names {
+		if isBuiltinFieldError(name) {
+			g.types[name] = "FieldError"
+			continue
+		}
+		g.types[name] = g.names.unique(exportName(name, "T"))
+	}
+	for _, name := range names {
+		s := schemas[name]
+		if g.types[name] == "FieldError" {
+			continue
+		}
+		g.p("")
+		if s.Description != "" {
+			g.p("// %s %s", g.types[name], oneLine(s.Description))
+		}
+		g.p("type %s %s", g.types[name], g.typeExpr(s))
+	}
+	for _, op := range sortedOperations(g.doc) {
+		g.operation(op)
+	}
+}
+
+// 參數與 body 合併成一個請求 struct, 都沒有時方法只收 ctx
+func (g *goGen) operation(op *operation) {
+	id := op.OperationId
+	if id == "" {
+		id = strings.ToLower(op.method) + op.path
+	}
+	name := g.names.unique(exportName(id, "Op"))
+	body := requestSchema(op.Operation)
+	type field struct {
+		name  string
+		param *openapi.Parameter
+	}
+	var fields []field
+	fieldNames := nameSet{"Body": body != nil}
+	for _, p := range op.Parameters {
+		fields = append(fields, field{name: fieldNames.unique(exportName(p.Name, "P")), param: p})
+	}
+
+	reqType := ""
+	if len(fields) > 0 || body != nil {
+		reqType = g.names.unique(name + "Request")
+		g.p("")
+		g.p("type %s struct {", reqType)
+		for _, f := range fields {
+			if f.param.Description != "" {
+				g.p("// %s", oneLine(f.param.Description))
+			}
+			g.p("%s %s `%s:%q`", f.name, g.typeExpr(f.param.Schema), f.param.In, f.param.Name)
+		}
+		if body != nil {
+			g.p("Body %s", g.typeExpr(body))
+		}
+		g.p("}")
+	}
+
+	_, respSchema := successResponse(op.Operation)
+	respType, respPtr := "", false
+	if respSchema != nil {
+		respType = g.typeExpr(respSchema)
+		respPtr = g.isStruct(respSchema)
+	}
+
+	g.p("")
+	if op.Summary != "" {
+		g.p("// %s %s", name, oneLine(op.Summary))
+		g.p("//")
+	}
+	if op.Deprecated {
+		g.p("// Deprecated: %s %s", op.method, op.path)
+	} else {
+		g.p("// %s %s", op.method, op.path)
+	}
+	args := "ctx context.Context"
+	if reqType != "" {
+		args += ", req *" + reqType
+	}
+	switch {
+	case respType == "":
+		g.p("func (c *Client) %s(%s) error {", name, args)
+	case respPtr:
+		g.p("func (c *Client) %s(%s) (*%s, error) {", name, args, respType)
+	default:
+		g.p("func (c *Client) %s(%s) (%s, error) {", name, args, respType)
+	}
+	if reqType != "" {
+		g.p("if req == nil {")
+		g.p("req = &%s{}", reqType)
+		g.p("}")
+	}
+
+	var path []string
+	params := map[string]string{}
+	for _, f := range fields {
+		if f.param.In == openapi.InPath {
+			params[f.param.Name] = f.name
+		}
+	}
+	for _, part := range splitPath(op.path) {
+		if pn, ok := isPathParam(part); ok && params[pn] != "" {
+			path = append(path, fmt.Sprintf("url.PathEscape(paramString(req.%s))", params[pn]))
+			continue
+		}
+		path = append(path, strconv.Quote(part))
+	}
+	g.p("path := %s", strings.Join(path, " + "))
+	g.p("q := url.Values{}")
+	g.p("h := http.Header{}")
+	for _, f := range fields {
+		switch f.param.In {
+		case openapi.InQuery:
+			g.p("addParam(func(v string) { q.Add(%q, v) }, req.%s, %t)", f.param.Name, f.name, f.param.Required)
+		case openapi.InHeader:
+			g.p("addParam(func(v string) { h.Add(%q, v) }, req.%s, %t)", f.param.Name, f.name, f.param.Required)
+		}
+	}
+	in := "nil"
+	if body != nil {
+		in = "req.Body"
+	}
+	auth := len(op.Security) > 0
+	switch {
+	case respType == "":
+		g.p("return c.do(ctx, %q, path, q, h, %t, %s, nil)", op.method, auth, in)
+	case respPtr:
+		g.p("var out %s", respType)
+		g.p("if err := c.do(ctx, %q, path, q, h, %t, %s, &out); err != nil {", op.method, auth, in)
+		g.p("return nil, err")
+		g.p("}")
+		g.p("return &out, nil")
+	default:
+		g.p("var out %s", respType)
+		g.p("err := c.do(ctx, %q, path, q, h, %t, %s, &out)", op.method, auth, in)
+		g.p("return out, err")
+	}
+	g.p("}")
+}
+
+func (g *goGen) isStruct(s *openapi.Schema) bool {
+	return s.Ref != "" || (s.Type == "object" && s.AdditionalProperties == nil && len(s.Properties) > 0)
+}
+
+func (g *goGen) typeExpr(s *openapi.Schema) string {
+	if s == nil {
+		return "json.RawMessage"
+	}
+	if s.Ref != "" {
+		if name, ok := g.types[openapi.RefName(s.Ref)]; ok {
+			return name
+		}
+		return "json.RawMessage"
+	}
+	switch s.Type {
+	case "string":
+		switch s.Format {
+		case "date-time":
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		if s.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		if s.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + g.typeExpr(s.Items)
+	case "object":
+		if s.AdditionalProperties != nil || len(s.Properties) == 0 {
+			return "map[string]" + g.typeExpr(s.AdditionalProperties)
+		}
+		return g.structExpr(s)
+	}
+	return "json.RawMessage"
+}
+
+// 非 required 的欄位加上 omitempty, 參照其他型別的欄位與 nullable 的欄位改用指標
+func (g *goGen) structExpr(s *openapi.Schema) string {
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	fieldNames := nameSet{}
+	for _, prop := range sortedKeys(s.Properties) {
+		ps := s.Properties[prop]
+		if ps.Description != "" {
+			fmt.Fprintf(&b, "// %s\n", oneLine(ps.Description))
+		}
+		typ := g.typeExpr(ps)
+		tag := prop
+		optional := !isRequired(s, prop)
+		if optional {
+			tag += ",omitempty"
+		}
+		if (optional && ps.Ref != "") || (ps.Nullable && !isNilable(typ)) {
+			typ = "*" + typ
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", fieldNames.unique(exportName(prop, "F")), typ, tag)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func isNilable(typ string) bool {
+	for _, prefix := range []string{"*", "[]", "map[", "json.RawMessage"} {
+		if strings.HasPrefix(typ, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+const goRuntime = `import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      func(ctx context.Context) (string, error)
+	header     http.Header
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// 需要驗證的 API 帶上 Authorization: Bearer <token>
+func WithToken(token string) Option {
+	return WithTokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// 每次需要驗證的請求都重新取得 token, 可在此處理更新
+func WithTokenSource(f func(ctx context.Context) (string, error)) Option {
+	return func(c *Client) {
+		c.token = f
+	}
+}
+
+// 每個請求都帶上的 header
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.header.Add(key, value)
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		header:     http.Header{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type FieldError struct {
+	Field   string ` + "`json:\"field\"`" + `
+	In      string ` + "`json:\"in\"`" + `
+	Message string ` + "`json:\"message\"`" + `
+}
+
+// 非 2xx 的回應, 與 errors.ApiError 介面相容
+type ApiError struct {
+	Status   int          ` + "`json:\"status\"`" + `
+	Message  string       ` + "`json:\"error\"`" + `
+	Service  string       ` + "`json:\"service\"`" + `
+	ErrorKey string       ` + "`json:\"errorKey\"`" + `
+	Fields   []FieldError ` + "`json:\"fields,omitempty\"`" + `
+	// 原始回應 body
+	Body []byte ` + "`json:\"-\"`" + `
+}
+
+func (e *ApiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	}
+	return e.Message
+}
+
+func (e *ApiError) GetStatus() int {
+	return e.Status
+}
+
+func (e *ApiError) GetKey() string {
+	return e.ErrorKey
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, auth bool, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	for k, vs := range c.header {
+		req.Header[k] = append(req.Header[k], vs...)
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth && c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &ApiError{}
+		if err := json.Unmarshal(b, apiErr); err != nil {
+			// 不是 JSON 的錯誤回應 (例如 proxy 的錯誤頁), 以原始 body 作為訊息
+			apiErr = &ApiError{Message: strings.TrimSpace(string(b))}
+		}
+		apiErr.Status = resp.StatusCode
+		apiErr.Body = b
+		return apiErr
+	}
+	if out == nil || len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func paramString(v interface{}) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(t)
+	}
+	return fmt.Sprint(v)
+}
+
+// 非 required 的零值不送出, slice 每個元素各送一次
+func addParam(set func(string), v interface{}, required bool) {
+	rv := reflect.ValueOf(v)
+	if !required && rv.IsZero() {
+		return
+	}
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < rv.Len(); i++ {
+			set(paramString(rv.Index(i).Interface()))
+		}
+		return
+	}
+	set(paramString(v))
+}`
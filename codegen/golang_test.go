@@ -0,0 +1,135 @@
+package codegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func loadSample(t *testing.T) *openapi.Document {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "sample.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &openapi.Document{}
+	if err = json.Unmarshal(b, doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// 以 go test ./codegen -update 重新產生
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the generated output, rerun with -update and review the diff", golden)
+	}
+}
+
+func TestGoClientGolden(t *testing.T) {
+	src, err := GoClient(loadSample(t), GoConf{Package: "sample"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "client.go.golden", src)
+}
+
+// 產生的 client 只依賴標準函式庫, 在獨立的 module 中編譯並執行 clientTest
+func TestGoClientBuild(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	src, err := GoClient(loadSample(t), GoConf{Package: "sample"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":         "module example.com/sample\n\ngo 1.20\n",
+		"client.go":      string(src),
+		"client_test.go": clientTest,
+	}
+	for name, content := range files {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{{"build", "./..."}, {"test", "./..."}} {
+		cmd := exec.Command(goBin, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %v: %v\n%s", args, err, out)
+		}
+	}
+}
+
+const clientTest = `package sample
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/7":
+			if r.Header.Get("Authorization") != "Bearer tok" {
+				t.Errorf("authorization: %q", r.Header.Get("Authorization"))
+			}
+			w.Write([]byte(` + "`" + `{"id":7,"name":"amy","role":"admin","nickname":null,"manager":null}` + "`" + `))
+		case "/users":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(` + "`" + `{"status":400,"error":"bad","errorKey":"invalid","fields":[{"field":"name","in":"body","message":"required"}]}` + "`" + `))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>bad gateway</html>\n"))
+		}
+	}))
+	defer ts.Close()
+	c := New(ts.URL, WithToken("tok"))
+	ctx := context.Background()
+
+	u, err := c.GetUser(ctx, &GetUserRequest{Id: 7})
+	if err != nil || u.Id != 7 || u.Role != "admin" || u.Nickname != nil {
+		t.Fatalf("GetUser: %+v %v", u, err)
+	}
+
+	var apiErr *ApiError
+	_, err = c.CreateUser(ctx, &CreateUserRequest{})
+	if !errors.As(err, &apiErr) || apiErr.Status != 400 || apiErr.ErrorKey != "invalid" || len(apiErr.Fields) != 1 || apiErr.Fields[0].In != "body" {
+		t.Fatalf("CreateUser: %#v", err)
+	}
+
+	err = c.DeleteFilesFileIdRawV1(ctx, &DeleteFilesFileIdRawV1Request{FileId: "a"})
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadGateway || apiErr.Error() != "<html>bad gateway</html>" || string(apiErr.Body) != "<html>bad gateway</html>\n" {
+		t.Fatalf("non-JSON error: %#v", err)
+	}
+}
+`
@@ -0,0 +1,152 @@
+// codegen 由 OpenAPI 文件 (GinApiServer.OpenAPI 或 openapi.json) 產生各語言的 client 程式碼
+// 輸出依路徑、method 與 schema 名稱排序, 同一份文件每次產生的內容相同
+//
+// go generate 可直接使用命令列工具:
+//
+//	//go:generate go run github.com/wayne011872/api-toolkit/cmd/apitool gen go -spec openapi.json -pkg userclient -out client.go
+//	//go:generate go run github.com/wayne011872/api-toolkit/cmd/apitool gen ts -spec openapi.json -out ../web/src/api.ts
+package codegen
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+// 以非英數字元分段, 每段首字大寫後接起來, 數字開頭時加上前綴
+func exportName(s string, prefix string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = prefix + name
+	}
+	return name
+}
+
+// 已使用的名稱, 重複時加上數字
+type nameSet map[string]bool
+
+func (n nameSet) unique(name string) string {
+	result := name
+	for i := 2; n[result]; i++ {
+		result = name + strconv.Itoa(i)
+	}
+	n[result] = true
+	return result
+}
+
+type operation struct {
+	method string
+	path   string
+	*openapi.Operation
+}
+
+func sortedOperations(doc *openapi.Document) []*operation {
+	var ops []*operation
+	for p, item := range doc.Paths {
+		if item == nil {
+			continue
+		}
+		for m, op := range *item {
+			if op != nil {
+				ops = append(ops, &operation{method: strings.ToUpper(m), path: p, Operation: op})
+			}
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].path != ops[j].path {
+			return ops[i].path < ops[j].path
+		}
+		return ops[i].method < ops[j].method
+	})
+	return ops
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 最小的 2xx 回應與其 JSON schema, 沒有 body 時 schema 為 nil
+func successResponse(op *openapi.Operation) (status int, schema *openapi.Schema) {
+	for _, code := range sortedKeys(op.Responses) {
+		s, err := strconv.Atoi(code)
+		if err != nil || s < 200 || s > 299 {
+			continue
+		}
+		if mt := op.Responses[code].Content[openapi.MimeJSON]; mt != nil {
+			return s, mt.Schema
+		}
+		return s, nil
+	}
+	return 0, nil
+}
+
+func requestSchema(op *openapi.Operation) *openapi.Schema {
+	if op.RequestBody == nil {
+		return nil
+	}
+	if mt := op.RequestBody.Content[openapi.MimeJSON]; mt != nil {
+		return mt.Schema
+	}
+	return nil
+}
+
+// 路徑切成靜態字串與參數, 例如 /v1/users/{id} 為 ["/v1/users/", "{id}"]
+func splitPath(p string) []string {
+	var parts []string
+	for p != "" {
+		i := strings.Index(p, "{")
+		j := strings.Index(p, "}")
+		if i < 0 || j < i {
+			parts = append(parts, p)
+			break
+		}
+		if i > 0 {
+			parts = append(parts, p[:i])
+		}
+		parts = append(parts, p[i:j+1])
+		p = p[j+1:]
+	}
+	return parts
+}
+
+func isPathParam(part string) (string, bool) {
+	if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+		return part[1 : len(part)-1], true
+	}
+	return "", false
+}
+
+// errors.FieldError 產生的 component 與 client 內建的 FieldError 相同, 直接沿用
+func isBuiltinFieldError(name string) bool {
+	return name == openapi.RefName(openapi.FieldErrorRef)
+}
+
+func isRequired(s *openapi.Schema, name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,480 @@
+package codegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+var (
+	tsIdent    = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+	tsReserved = []string{"Client", "ClientOptions", "RequestOptions", "TokenSource", "ApiError", "ApiErrorBody", "FieldError"}
+)
+
+// 產生單一 TypeScript 檔案: components 的 interface、每個 operation 的請求 interface
+// 與每個 GinAPI 一個 class (依 x-gin-api 分組, 沒有時依第一個 tag)
+// json tag 有 omitempty 的屬性為 optional, 其他屬性在 Go 輸出的 JSON 中一定存在
+func TypeScriptClient(doc *openapi.Document) []byte {
+	g := &tsGen{
+		doc:   doc,
+		names: nameSet{},
+		types: map[string]string{},
+	}
+	for _, n := range tsReserved {
+		g.names[n] = true
+	}
+	g.file()
+	return g.buf.Bytes()
+}
+
+type tsGen struct {
+	doc   *openapi.Document
+	buf   bytes.Buffer
+	names nameSet
+	types map[string]string
+}
+
+func (g *tsGen) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *tsGen) file() {
+	g.p("// Code generated by apitool gen ts. DO NOT EDIT.")
+	g.p("// %s %s", g.doc.Info.Title, g.doc.Info.Version)
+	g.p("%s", tsRuntime)
+
+	var schemas map[string]*openapi.Schema
+	if g.doc.Components != nil {
+		schemas = g.doc.Components.Schemas
+	}
+	names := sortedKeys(schemas)
+	for _, name := range names {
+		if isBuiltinFieldError(name) {
+			g.types[name] = "FieldError"
+			continue
+		}
+		g.types[name] = g.names.unique(exportName(name, "T"))
+	}
+	for _, name := range names {
+		s := schemas[name]
+		if g.types[name] == "FieldError" {
+			continue
+		}
+		g.p("")
+		g.comment("", s.Description)
+		if s.Type == "object" && s.AdditionalProperties == nil {
+			g.p("export interface %s %s", g.types[name], g.objectExpr(s, ""))
+			continue
+		}
+		g.p("export type %s = %s;", g.types[name], g.typeExpr(s, ""))
+	}
+
+	groups := map[string][]*operation{}
+	for _, op := range sortedOperations(g.doc) {
+		group := op.Api
+		if group == "" && len(op.Tags) > 0 {
+			group = op.Tags[0]
+		}
+		groups[group] = append(groups[group], op)
+	}
+	for _, group := range sortedKeys(groups) {
+		g.class(group, groups[group])
+	}
+}
+
+func (g *tsGen) comment(indent string, lines ...string) {
+	var ls []string
+	for _, l := range lines {
+		if l = oneLine(l); l != "" {
+			ls = append(ls, strings.ReplaceAll(l, "*/", "*\\/"))
+		}
+	}
+	switch len(ls) {
+	case 0:
+	case 1:
+		g.p("%s/** %s */", indent, ls[0])
+	default:
+		g.p("%s/**", indent)
+		for _, l := range ls {
+			g.p("%s * %s", indent, l)
+		}
+		g.p("%s */", indent)
+	}
+}
+
+type tsMethod struct {
+	op      *operation
+	name    string
+	reqType string
+	// 請求沒有必填欄位時可省略
+	reqOptional bool
+}
+
+func (g *tsGen) class(group string, ops []*operation) {
+	className := g.names.unique(exportName(group, "Api"))
+	methodNames := nameSet{}
+	var methods []*tsMethod
+	for _, op := range ops {
+		id := op.OperationId
+		if id == "" {
+			id = strings.ToLower(op.method) + op.path
+		}
+		m := &tsMethod{op: op}
+		base := exportName(id, "Op")
+		m.name = methodNames.unique(strings.ToLower(base[:1]) + base[1:])
+		m.reqType, m.reqOptional = g.request(base, op)
+		methods = append(methods, m)
+	}
+
+	g.p("")
+	g.p("export class %s {", className)
+	g.p("  constructor(private readonly client: Client) {}")
+	for _, m := range methods {
+		g.method(m)
+	}
+	g.p("}")
+}
+
+// 參數依位置分成 params/query/headers, 加上 body; 都沒有時回傳空字串
+func (g *tsGen) request(base string, op *operation) (string, bool) {
+	body := requestSchema(op.Operation)
+	var groups []string
+	optional := true
+	for _, in := range []struct{ key, in string }{
+		{"params", openapi.InPath}, {"query", openapi.InQuery}, {"headers", openapi.InHeader},
+	} {
+		var props []string
+		required := false
+		for _, p := range op.Parameters {
+			if p.In != in.in {
+				continue
+			}
+			mark := "?"
+			if p.Required {
+				mark = ""
+				required = true
+			}
+			prop := fmt.Sprintf("%s%s: %s;", tsKey(p.Name), mark, g.typeExpr(p.Schema, "    "))
+			if p.Description != "" {
+				prop = fmt.Sprintf("/** %s */ %s", strings.ReplaceAll(oneLine(p.Description), "*/", "*\\/"), prop)
+			}
+			props = append(props, prop)
+		}
+		if len(props) == 0 {
+			continue
+		}
+		mark := "?"
+		if required {
+			mark = ""
+			optional = false
+		}
+		groups = append(groups, fmt.Sprintf("  %s%s: {\n    %s\n  };", in.key, mark, strings.Join(props, "\n    ")))
+	}
+	if body != nil {
+		mark := "?"
+		if op.RequestBody.Required {
+			mark = ""
+			optional = false
+		}
+		groups = append(groups, fmt.Sprintf("  body%s: %s;", mark, g.typeExpr(body, "  ")))
+	}
+	if len(groups) == 0 {
+		return "", true
+	}
+	name := g.names.unique(base + "Request")
+	g.p("")
+	g.p("export interface %s {", name)
+	for _, grp := range groups {
+		g.p("%s", grp)
+	}
+	g.p("}")
+	return name, optional
+}
+
+func (g *tsGen) method(m *tsMethod) {
+	op := m.op
+	lines := []string{op.Summary}
+	if op.Description != "" && op.Description != op.Summary {
+		lines = append(lines, op.Description)
+	}
+	lines = append(lines, op.method+" "+op.path)
+	if op.Deprecated {
+		lines = append(lines, "@deprecated")
+	}
+	g.p("")
+	g.comment("  ", lines...)
+
+	var args []string
+	switch {
+	case m.reqType == "":
+	case m.reqOptional:
+		args = append(args, fmt.Sprintf("req: %s = {}", m.reqType))
+	default:
+		args = append(args, fmt.Sprintf("req: %s", m.reqType))
+	}
+	args = append(args, "init?: RequestInit")
+
+	resp := "void"
+	if _, s := successResponse(op.Operation); s != nil {
+		resp = g.typeExpr(s, "  ")
+	}
+
+	var path strings.Builder
+	path.WriteString("`")
+	for _, part := range splitPath(op.path) {
+		if name, ok := isPathParam(part); ok && m.reqType != "" && hasParam(op, name, openapi.InPath) {
+			fmt.Fprintf(&path, "${encodeURIComponent(String(req.params%s))}", tsAccess(name))
+			continue
+		}
+		// splitPath 會把 ${raw} 切成 $ 與 {raw}, 所以每個 $ 都要跳脫
+		r := strings.NewReplacer("\\", "\\\\", "`", "\\`", "$", "\\$")
+		path.WriteString(r.Replace(part))
+	}
+	path.WriteString("`")
+
+	var opts []string
+	if hasIn(op, openapi.InQuery) {
+		opts = append(opts, "query: req.query")
+	}
+	if hasIn(op, openapi.InHeader) {
+		opts = append(opts, "headers: req.headers")
+	}
+	if requestSchema(op.Operation) != nil {
+		opts = append(opts, "body: req.body")
+	}
+	if len(op.Security) > 0 {
+		opts = append(opts, "auth: true")
+	}
+	optsExpr := "{}"
+	if len(opts) > 0 {
+		optsExpr = "{ " + strings.Join(opts, ", ") + " }"
+	}
+	g.p("  %s(%s): Promise<%s> {", m.name, strings.Join(args, ", "), resp)
+	g.p("    return this.client.request<%s>(%q, %s, %s, init);", resp, op.method, path.String(), optsExpr)
+	g.p("  }")
+}
+
+func hasIn(op *operation, in string) bool {
+	for _, p := range op.Parameters {
+		if p.In == in {
+			return true
+		}
+	}
+	return false
+}
+
+func hasParam(op *operation, name, in string) bool {
+	for _, p := range op.Parameters {
+		if p.In == in && p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tsKey(name string) string {
+	if tsIdent.MatchString(name) {
+		return name
+	}
+	return tsString(name)
+}
+
+func tsAccess(name string) string {
+	if tsIdent.MatchString(name) {
+		return "." + name
+	}
+	return "[" + tsString(name) + "]"
+}
+
+// JSON 字串也是合法的 TypeScript 字串
+func tsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// indent 為目前這層的縮排, inline object 的屬性再多縮排兩格
+func (g *tsGen) typeExpr(s *openapi.Schema, indent string) string {
+	expr := g.valueExpr(s, indent)
+	if s != nil && s.Nullable && expr != "unknown" {
+		return expr + " | null"
+	}
+	return expr
+}
+
+func (g *tsGen) valueExpr(s *openapi.Schema, indent string) string {
+	if s == nil {
+		return "unknown"
+	}
+	if s.Ref != "" {
+		if name, ok := g.types[openapi.RefName(s.Ref)]; ok {
+			return name
+		}
+		return "unknown"
+	}
+	if len(s.Enum) > 0 {
+		vals := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			b, _ := json.Marshal(v)
+			vals[i] = string(b)
+		}
+		return strings.Join(vals, " | ")
+	}
+	switch s.Type {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		item := g.typeExpr(s.Items, indent)
+		if strings.Contains(item, " | ") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object":
+		if s.AdditionalProperties != nil || len(s.Properties) == 0 {
+			return "Record<string, " + g.typeExpr(s.AdditionalProperties, indent) + ">"
+		}
+		return g.objectExpr(s, indent)
+	}
+	return "unknown"
+}
+
+func (g *tsGen) objectExpr(s *openapi.Schema, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	inner := indent + "  "
+	for _, prop := range sortedKeys(s.Properties) {
+		ps := s.Properties[prop]
+		if ps.Description != "" {
+			fmt.Fprintf(&b, "%s/** %s */\n", inner, strings.ReplaceAll(oneLine(ps.Description), "*/", "*\\/"))
+		}
+		mark := ""
+		if ps.OmitEmpty {
+			mark = "?"
+		}
+		fmt.Fprintf(&b, "%s%s%s: %s;\n", inner, tsKey(prop), mark, g.typeExpr(ps, inner))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+const tsRuntime = `
+export interface FieldError {
+  field: string;
+  in: string;
+  message: string;
+}
+
+export interface ApiErrorBody {
+  status: number;
+  error: string;
+  service: string;
+  errorKey: string;
+  fields?: FieldError[];
+}
+
+/** 非 2xx 的回應 */
+export class ApiError extends Error {
+  readonly status: number;
+  readonly errorKey: string;
+  readonly fields: FieldError[];
+  readonly body: unknown;
+
+  constructor(status: number, body: unknown) {
+    const b = (typeof body === "object" && body !== null ? body : {}) as Partial<ApiErrorBody>;
+    super(b.error || ` + "`HTTP ${status}`" + `);
+    this.name = "ApiError";
+    this.status = status;
+    this.errorKey = b.errorKey ?? "";
+    this.fields = b.fields ?? [];
+    this.body = body;
+  }
+}
+
+/** 需要驗證的 API 帶上 Authorization: Bearer <token>, 函式每次請求都會呼叫 */
+export type TokenSource = string | (() => string | Promise<string>);
+
+export interface ClientOptions {
+  baseUrl: string;
+  token?: TokenSource;
+  /** 每個請求都帶上的 header */
+  headers?: Record<string, string>;
+  fetch?: typeof fetch;
+}
+
+export interface RequestOptions {
+  query?: object;
+  headers?: object;
+  body?: unknown;
+  auth?: boolean;
+}
+
+export class Client {
+  private readonly baseUrl: string;
+
+  constructor(private readonly options: ClientOptions) {
+    this.baseUrl = options.baseUrl.replace(/\/+$/, "");
+  }
+
+  async request<T>(method: string, path: string, opts: RequestOptions, init?: RequestInit): Promise<T> {
+    const headers = new Headers(init?.headers);
+    for (const [k, v] of Object.entries(this.options.headers ?? {})) {
+      headers.set(k, v);
+    }
+    for (const [k, v] of Object.entries(opts.headers ?? {})) {
+      if (v !== undefined && v !== null) {
+        headers.set(k, String(v));
+      }
+    }
+    headers.set("Accept", "application/json");
+    let body: string | undefined;
+    if (opts.body !== undefined) {
+      headers.set("Content-Type", "application/json");
+      body = JSON.stringify(opts.body);
+    }
+    const token = this.options.token;
+    if (opts.auth && token !== undefined) {
+      headers.set("Authorization", "Bearer " + (typeof token === "function" ? await token() : token));
+    }
+    const doFetch = this.options.fetch ?? fetch;
+    const resp = await doFetch(this.baseUrl + path + queryString(opts.query), { ...init, method, headers, body });
+    const text = await resp.text();
+    let data: unknown = undefined;
+    if (text !== "") {
+      try {
+        data = JSON.parse(text);
+      } catch {
+        data = text;
+      }
+    }
+    if (!resp.ok) {
+      throw new ApiError(resp.status, data);
+    }
+    return data as T;
+  }
+}
+
+/** 陣列每個元素各送一次, undefined 與 null 不送出 */
+function queryString(query?: object): string {
+  if (!query) {
+    return "";
+  }
+  const params = new URLSearchParams();
+  for (const [k, v] of Object.entries(query)) {
+    if (v === undefined || v === null) {
+      continue;
+    }
+    for (const item of Array.isArray(v) ? v : [v]) {
+      params.append(k, String(item));
+    }
+  }
+  const s = params.toString();
+  return s ? "?" + s : "";
+}`
@@ -0,0 +1,7 @@
+package codegen
+
+import "testing"
+
+func TestTypeScriptClientGolden(t *testing.T) {
+	checkGolden(t, "client.ts.golden", TypeScriptClient(loadSample(t)))
+}
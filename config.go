@@ -40,6 +40,10 @@ type Config struct {
 	preAuthMiddles []mid.GinMiddle
 	middles        []mid.GinMiddle
 	apis           []GinAPI
+	perms          []auth.ApiPerm
+	openapi        *OpenAPIConf
+	docs           *DocsConf
+	validate       *ValidateConf
 	errorHandler   errors.GinServerErrorHandler
 	Logger         Log
 }
@@ -64,33 +68,30 @@ func (cfg *Config) SetAPIs(apis ...GinAPI) {
 	cfg.apis = apis
 }
 
-func (cfg *Config) getMiddles() []mid.GinMiddle {
-	count := 0
-	var middles []mid.GinMiddle
-	hasAuth := cfg.authMid != nil
-	if cfg.Debug && hasAuth {
-		middles = make([]mid.GinMiddle, len(cfg.preAuthMiddles)+len(cfg.middles)+2)
-		middles[0] = mid.NewGinDebugMid()
-		count = 1
-	} else if hasAuth {
-		middles = make([]mid.GinMiddle, len(cfg.preAuthMiddles)+len(cfg.middles)+1)
-	} else {
-		middles = make([]mid.GinMiddle, len(cfg.preAuthMiddles)+len(cfg.middles))
-	}
-	for i := 0; i < len(cfg.preAuthMiddles); i++ {
-		middles[count] = cfg.preAuthMiddles[i]
-		count++
-	}
-	if hasAuth {
-		middles[count] = cfg.authMid
-		count++
-	}
+// 已知權限, 設定後會檢查路由的 Group
+func (cfg *Config) SetPerms(perms ...auth.ApiPerm) {
+	cfg.perms = perms
+}
+
+func (cfg *Config) SetOpenAPI(conf OpenAPIConf) {
+	cfg.openapi = &conf
+}
+
+func (cfg *Config) SetDocs(conf DocsConf) {
+	cfg.docs = &conf
+}
 
-	for i := 0; i < len(cfg.middles); i++ {
-		middles[count] = cfg.middles[i]
-		count++
+// 沒有設定 conf.Response 時, Debug 也驗證回應
+func (cfg *Config) SetValidation(conf ValidateConf) {
+	cfg.validate = &conf
+}
+
+func (cfg *Config) getPreAuthMiddles() []mid.GinMiddle {
+	var middles []mid.GinMiddle
+	if cfg.Debug && cfg.authMid != nil {
+		middles = append(middles, mid.NewGinDebugMid())
 	}
-	return middles
+	return append(middles, cfg.preAuthMiddles...)
 }
 
 func (cfg *Config) AddProms(c ...prometheus.Collector) {
@@ -0,0 +1,133 @@
+package apitool
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mvrilo/go-redoc"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"github.com/wayne011872/api-toolkit/auth"
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+type DocsUI string
+
+const (
+	// 使用內嵌的 swagger-ui 靜態檔, 不需要對外連線
+	DocsSwaggerUI DocsUI = "swagger"
+	// 使用 github.com/mvrilo/go-redoc 內嵌的 redoc.standalone.js, 版本由 go.mod 固定
+	DocsRedoc DocsUI = "redoc"
+
+	defaultDocsPath = "/docs"
+	redocScriptName = "redoc.standalone.js"
+)
+
+type DocsConf struct {
+	// 預設 /docs, 頁面使用的文件在 Path/openapi.json, 靜態檔在 Path/assets/
+	Path  string
+	UI    DocsUI
+	Title string
+	// 頁面與文件都經過 auth middleware
+	Auth  bool
+	Group []auth.ApiPerm
+	// release 模式預設不提供, 設為 true 才註冊
+	EnableInRelease bool
+}
+
+//go:embed docs/*.html
+var docsTemplates embed.FS
+
+var docsTmpl = template.Must(template.ParseFS(docsTemplates, "docs/*.html"))
+
+func (serv *ginApiServ) ServeDocs(conf DocsConf) GinApiServer {
+	if gin.Mode() == gin.ReleaseMode && !conf.EnableInRelease {
+		return serv
+	}
+	api, err := newDocsAPI(serv, conf)
+	if err != nil {
+		serv.routeErrs = append(serv.routeErrs, &RouteError{API: "docs", Method: http.MethodGet, Path: conf.Path, Err: err})
+		return serv
+	}
+	return serv.AddAPIs(api)
+}
+
+type docsAPI struct {
+	errors.CommonApiErrorHandler
+	serv   *ginApiServ
+	conf   DocsConf
+	assets http.FileSystem
+	// 不在 assets 中的單一檔案, 以檔名對應內容
+	files map[string][]byte
+	page  []byte
+}
+
+func newDocsAPI(serv *ginApiServ, conf DocsConf) (*docsAPI, error) {
+	if conf.Path == "" {
+		conf.Path = defaultDocsPath
+	}
+	conf.Path = strings.TrimSuffix(conf.Path, "/")
+	if conf.UI == "" {
+		conf.UI = DocsSwaggerUI
+	}
+	if conf.Title == "" {
+		conf.Title = serv.service
+	}
+	a := &docsAPI{serv: serv, conf: conf}
+	assetsPath := conf.Path + "/assets"
+	data := map[string]string{
+		"Title":   conf.Title,
+		"SpecURL": conf.Path + defaultOpenAPIPath,
+		"Assets":  assetsPath,
+	}
+	var tmpl string
+	switch conf.UI {
+	case DocsSwaggerUI:
+		tmpl = "swagger.html"
+		a.assets = http.FS(swaggerFiles.FS)
+	case DocsRedoc:
+		tmpl = "redoc.html"
+		a.files = map[string][]byte{redocScriptName: []byte(redoc.JavaScript)}
+	default:
+		return nil, fmt.Errorf("unknown docs ui %q", conf.UI)
+	}
+	var buf bytes.Buffer
+	if err := docsTmpl.ExecuteTemplate(&buf, tmpl, data); err != nil {
+		return nil, err
+	}
+	a.page = buf.Bytes()
+	return a, nil
+}
+
+func (a *docsAPI) GetAPIs() []*GinApiHandler {
+	hidden := &ApiDoc{Hidden: true}
+	return []*GinApiHandler{
+		{Path: a.conf.Path, Handler: a.pageHandler, Method: "GET", Auth: a.conf.Auth, Group: a.conf.Group, Doc: hidden},
+		{Path: a.conf.Path + defaultOpenAPIPath, Handler: a.serv.openAPIHandler, Method: "GET", Auth: a.conf.Auth, Group: a.conf.Group, Doc: hidden},
+		{Path: a.conf.Path + "/assets/*file", Handler: a.assetHandler, Method: "GET", Auth: a.conf.Auth, Group: a.conf.Group, Doc: hidden},
+	}
+}
+
+func (a *docsAPI) pageHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", a.page)
+}
+
+func (a *docsAPI) assetHandler(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("file"), "/")
+	if a.assets == nil {
+		data, ok := a.files[name]
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Data(http.StatusOK, mime.TypeByExtension(path.Ext(name)), data)
+		return
+	}
+	c.FileFromFS(name, a.assets)
+}
@@ -0,0 +1,76 @@
+package apitool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeDocs(t *testing.T) {
+	for _, tc := range []struct {
+		ui     DocsUI
+		assets []string
+	}{
+		{DocsSwaggerUI, []string{"swagger-ui-bundle.js", "swagger-ui-standalone-preset.js", "swagger-ui.css"}},
+		{DocsRedoc, []string{"redoc.standalone.js"}},
+	} {
+		t.Run(string(tc.ui), func(t *testing.T) {
+			serv := NewGinApiServer(gin.TestMode, "svc").
+				SetServerErrorHandler(func(c *gin.Context, service string, err error) {
+					t.Error(err)
+				}).
+				ServeDocs(DocsConf{Path: "/docs/", UI: tc.ui, Title: "Test API"})
+			server, err := serv.GetServerE(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			get := func(path string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				return w
+			}
+
+			w := get("/docs")
+			if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+				t.Fatalf("page: %d %s", w.Code, w.Header().Get("Content-Type"))
+			}
+			page := w.Body.String()
+			for _, want := range []string{"<title>Test API</title>", `"/docs/openapi.json"`, "/docs/assets/" + tc.assets[0]} {
+				if !strings.Contains(page, want) {
+					t.Errorf("page missing %s", want)
+				}
+			}
+			// 頁面不可從外部載入 script
+			if strings.Contains(page, "https://") {
+				t.Errorf("page loads external resources:\n%s", page)
+			}
+
+			for _, name := range tc.assets {
+				w = get("/docs/assets/" + name)
+				if w.Code != http.StatusOK || w.Body.Len() == 0 {
+					t.Errorf("%s: %d, %d bytes", name, w.Code, w.Body.Len())
+				}
+				if path.Ext(name) == ".js" && !strings.Contains(w.Header().Get("Content-Type"), "javascript") {
+					t.Errorf("%s content type %s", name, w.Header().Get("Content-Type"))
+				}
+			}
+			if w = get("/docs/assets/missing.js"); w.Code != http.StatusNotFound {
+				t.Errorf("missing asset: %d", w.Code)
+			}
+			if w = get("/docs/openapi.json"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"openapi"`) {
+				t.Errorf("spec: %d %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeDocsUnknownUI(t *testing.T) {
+	serv := NewGinApiServer(gin.TestMode, "svc").ServeDocs(DocsConf{UI: "rapidoc"})
+	if err := serv.Err(); err == nil || !strings.Contains(err.Error(), "unknown docs ui") {
+		t.Fatalf("err %v", err)
+	}
+}
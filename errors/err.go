@@ -17,11 +17,13 @@ type GinServerErrorHandler func(c *gin.Context, service string, err error)
 
 type ApiError interface {
 	GetStatus() int
+	GetKey() string
 	error
 }
 
 type myApiError struct {
 	statusCode int
+	key        string
 	error
 }
 
@@ -29,6 +31,10 @@ func (e myApiError) GetStatus() int {
 	return e.statusCode
 }
 
+func (e myApiError) GetKey() string {
+	return e.key
+}
+
 func (e myApiError) String() string {
 	return fmt.Sprintf("%v: %v", e.statusCode, e.error)
 }
@@ -37,6 +43,10 @@ func New(status int, msg string) ApiError {
 	return myApiError{statusCode: status, error: errors.New(msg)}
 }
 
+func NewWithKey(status int, key string, msg string) ApiError {
+	return myApiError{statusCode: status, key: key, error: errors.New(msg)}
+}
+
 func PkgError(status int, err error) ApiError {
 	return myApiError{statusCode: status, error: err}
 }
@@ -50,9 +60,21 @@ func (api *CommonApiErrorHandler) SetApiErrorHandler(handler GinApiErrorHandler)
 }
 
 var (
-	Error_Auth_Path_NotFound  = New(http.StatusNotFound, "auth path not found")
-	Error_Auth_Miss_Token     = New(http.StatusUnauthorized, "miss token")
-	Error_Auth_Invalid_Token  = New(http.StatusUnauthorized, "invalid token")
-	Error_Auth_Host_Not_Match = New(http.StatusUnauthorized, "host not match")
-	Error_Auth_No_Perm        = New(http.StatusUnauthorized, "no permission")
+	Error_Auth_Path_NotFound       = New(http.StatusNotFound, "auth path not found")
+	Error_Auth_Miss_Token          = New(http.StatusUnauthorized, "miss token")
+	Error_Auth_Invalid_Token       = New(http.StatusUnauthorized, "invalid token")
+	Error_Auth_Host_Not_Match      = New(http.StatusUnauthorized, "host not match")
+	Error_Auth_No_Perm             = New(http.StatusUnauthorized, "no permission")
+	Error_Auth_Mfa_Required        = NewWithKey(http.StatusUnauthorized, "mfa_required", "mfa required")
+	Error_Auth_Mfa_Expired         = NewWithKey(http.StatusUnauthorized, "mfa_required", "mfa expired")
+	Error_Auth_Too_Many_Attempts   = NewWithKey(http.StatusTooManyRequests, "too_many_attempts", "too many attempts")
+	Error_Auth_Locked              = NewWithKey(http.StatusTooManyRequests, "account_locked", "account temporarily locked")
+	Error_Auth_Invalid_DPoP        = NewWithKey(http.StatusUnauthorized, "invalid_dpop_proof", "invalid dpop proof")
+	Error_Auth_Presign_Invalid     = NewWithKey(http.StatusForbidden, "invalid_signature", "invalid or expired signed url")
+	Error_Auth_Presign_Not_Allowed = NewWithKey(http.StatusForbidden, "invalid_signature", "signed url not allowed")
+	Error_Auth_Session_Invalid     = NewWithKey(http.StatusUnauthorized, "invalid_session", "session revoked or expired")
+	Error_OneTime_Invalid          = NewWithKey(http.StatusBadRequest, "link_invalid", "invalid link")
+	Error_OneTime_Expired          = NewWithKey(http.StatusBadRequest, "link_expired", "link expired")
+	Error_OneTime_Used             = NewWithKey(http.StatusBadRequest, "link_used", "link already used")
+	Error_Request_Too_Large        = NewWithKey(http.StatusRequestEntityTooLarge, "request_too_large", "request body too large")
 )
@@ -0,0 +1,59 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+	InBody   = "body"
+)
+
+type FieldError struct {
+	// 參數名稱或 body 內的路徑, 例如 items[0].name, 整個 body 的錯誤為空字串
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+// server error handler 可檢查此介面, 把欄位錯誤放進回應
+type FieldErrors interface {
+	ApiError
+	GetFields() []FieldError
+}
+
+type validationError struct {
+	fields []FieldError
+}
+
+func NewValidationError(fields []FieldError) FieldErrors {
+	return &validationError{fields: fields}
+}
+
+func (e *validationError) GetStatus() int {
+	return http.StatusBadRequest
+}
+
+func (e *validationError) GetKey() string {
+	return "validation_failed"
+}
+
+func (e *validationError) GetFields() []FieldError {
+	return e.fields
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		if f.Field == "" {
+			msgs[i] = fmt.Sprintf("%s: %s", f.In, f.Message)
+			continue
+		}
+		msgs[i] = fmt.Sprintf("%s %s: %s", f.In, f.Field, f.Message)
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
@@ -1,8 +1,12 @@
 package apitool
 
 import (
+	"fmt"
 	"net/http"
+	"reflect"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,14 +14,28 @@ import (
 	"github.com/wayne011872/api-toolkit/auth"
 	"github.com/wayne011872/api-toolkit/errors"
 	"github.com/wayne011872/api-toolkit/mid"
+	"github.com/wayne011872/api-toolkit/openapi"
 )
 
 type GinApiHandler struct {
 	Handler func(c *gin.Context)
-	Method  string
+	// 任何 HTTP method 或自訂 method, MethodAny 代表全部標準 method
+	Method string
+	// 同一個 handler 註冊多個 method, 可與 Method 並用
+	Methods []string
 	Path    string
 	Auth    bool
-	Group   []auth.ApiPerm
+	// 為 true 時不套用群組的 Auth 與 Group, 不可與 Auth 同時設定
+	Public bool
+	Group  []auth.ApiPerm
+	// 大於 0 時要求使用者在此時間內完成過 MFA (step-up), 須搭配 Auth
+	MfaMaxAge time.Duration
+	// 允許以預簽 URL 存取 (搭配 auth.NewGinPresignMid)
+	Presign bool
+	// 只套用在此路由, 在全域與群組 middleware 之後執行
+	Middles []mid.GinMiddle
+	// OpenAPI 文件的說明, 沒有設定時仍會列出路由
+	Doc *ApiDoc
 }
 
 type GinAPI interface {
@@ -26,16 +44,32 @@ type GinAPI interface {
 }
 
 type GinApiServer interface {
+	// 路由在 Run/GetServer/Err 時才註冊, 之前設定的 middleware 都會套用
 	AddAPIs(handlers ...GinAPI) GinApiServer
+	// 在 auth middleware 之前執行
+	PreAuthMiddles(mids ...mid.GinMiddle) GinApiServer
+	// 在 auth middleware 之後執行, 明確傳入 auth middleware 時依傳入的順序
 	Middles(mids ...mid.GinMiddle) GinApiServer
 	SetServerErrorHandler(errors.GinServerErrorHandler) GinApiServer
 	SetAuth(authmid auth.GinAuthMidInter) GinApiServer
+	// 設定已知權限, 需要驗證的路由 Group 中出現其他權限視為註冊錯誤
+	SetPerms(perms ...auth.ApiPerm) GinApiServer
 	SetTrustedProxies([]string) GinApiServer
 	SetPromhttp(c ...prometheus.Collector) GinApiServer
 	Static(relativePath, root string) GinApiServer
+	// 在 conf.Path 提供依路由產生的 OpenAPI 3.1 文件
+	ServeOpenAPI(conf OpenAPIConf) GinApiServer
+	OpenAPI() *openapi.Document
+	// 在 conf.Path 提供內嵌的 Swagger UI 或 Redoc 頁面, release 模式預設不註冊
+	ServeDocs(conf DocsConf) GinApiServer
 	Run(port int) error
+	// 註冊路由時累積的錯誤, 沒有錯誤時為 nil
+	Err() error
 	errorHandler(c *gin.Context, err error)
+	// 路由有錯誤時 panic
 	GetServer(port int) *http.Server
+	// 路由有錯誤時回傳 Err 的錯誤
+	GetServerE(port int) (*http.Server, error)
 }
 
 type ginApiServ struct {
@@ -43,7 +77,24 @@ type ginApiServ struct {
 	service      string
 	authMid      auth.GinAuthMidInter
 	myErrHandler errors.GinServerErrorHandler
-	apiMids      []gin.HandlerFunc
+	preAuthMids  []mid.GinMiddle
+	apiMids      []mid.GinMiddle
+	apis         []GinAPI
+	perms        map[auth.ApiPerm]bool
+	routeErrs    RouteErrors
+	docRoutes    []docRoute
+	openapiConf  *OpenAPIConf
+	openapiMu    sync.Mutex
+	openapiDoc   *openapi.Document
+	openapiOnce  sync.Once
+}
+
+func (serv *ginApiServ) Err() error {
+	serv.build()
+	if len(serv.routeErrs) == 0 {
+		return nil
+	}
+	return serv.routeErrs
 }
 
 func (serv *ginApiServ) SetServerErrorHandler(handler errors.GinServerErrorHandler) GinApiServer {
@@ -65,37 +116,166 @@ func (serv *ginApiServ) SetAuth(authMid auth.GinAuthMidInter) GinApiServer {
 	return serv
 }
 
+func (serv *ginApiServ) PreAuthMiddles(mids ...mid.GinMiddle) GinApiServer {
+	serv.preAuthMids = append(serv.preAuthMids, mids...)
+	return serv
+}
+
 func (serv *ginApiServ) Middles(mids ...mid.GinMiddle) GinApiServer {
+	serv.apiMids = append(serv.apiMids, mids...)
+	return serv
+}
+
+func (serv *ginApiServ) AddAPIs(apis ...GinAPI) GinApiServer {
+	serv.apis = append(serv.apis, apis...)
+	return serv
+}
+
+// 全域 middleware 固定為 pre-auth → auth → 其他, 與呼叫順序無關
+// auth middleware 也明確加進 PreAuthMiddles/Middles 時依呼叫端的位置, 且只保留一個
+func (serv *ginApiServ) globalMiddles() []mid.GinMiddle {
+	mids := append(serv.preAuthMids[:len(serv.preAuthMids):len(serv.preAuthMids)], serv.apiMids...)
+	if serv.authMid == nil {
+		return mids
+	}
+	var result []mid.GinMiddle
+	placed := false
 	for _, m := range mids {
+		if isSameMiddle(m, serv.authMid) {
+			if placed {
+				continue
+			}
+			placed = true
+		}
+		result = append(result, m)
+	}
+	if placed {
+		return result
+	}
+	n := len(serv.preAuthMids)
+	return append(append(result[:n:n], serv.authMid), result[n:]...)
+}
+
+func isSameMiddle(a, b mid.GinMiddle) bool {
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+func (serv *ginApiServ) middleHandlers(mids []mid.GinMiddle) []gin.HandlerFunc {
+	handlers := make([]gin.HandlerFunc, len(mids))
+	for i, m := range mids {
 		m.SetApiErrorHandler(serv.errorHandler)
-		serv.apiMids = append(serv.apiMids, m.Handler())
-		//serv.Engine.Use(m.Handler())
+		handlers[i] = m.Handler()
 	}
-	return serv
+	return handlers
 }
 
-func (serv *ginApiServ) AddAPIs(apis ...GinAPI) GinApiServer {
+// 註冊尚未註冊的 API, 重複呼叫只處理新加入的部分
+// 先檢查整份路由表, 有任何錯誤就不註冊, 錯誤由 Err 回傳
+func (serv *ginApiServ) build() {
+	if serv.openapiConf != nil {
+		serv.openapiOnce.Do(func() {
+			if err := serv.handle(http.MethodGet, serv.openapiConf.Path, []gin.HandlerFunc{serv.openAPIHandler}); err != nil {
+				serv.routeErrs = append(serv.routeErrs, &RouteError{
+					API: "openapi", Method: http.MethodGet, Path: serv.openapiConf.Path, Err: err,
+				})
+			}
+		})
+	}
+	if len(serv.apis) == 0 {
+		return
+	}
+	apis := serv.apis
+	serv.apis = nil
+
+	table := routeTable{}
+	for _, r := range serv.Engine.Routes() {
+		table.add(r.Method, r.Path, "existing route")
+	}
+	type validRoute struct {
+		*ginRoute
+		methods []string
+	}
+	var routes []validRoute
+	var errs RouteErrors
+	for _, api := range apis {
+		if api == nil {
+			errs = append(errs, &RouteError{API: "<nil>", Err: fmt.Errorf("nil GinAPI")})
+			continue
+		}
+		for _, r := range flattenRoutes(api) {
+			methods, rerrs := serv.validateRoute(table, r)
+			errs = append(errs, rerrs...)
+			routes = append(routes, validRoute{ginRoute: r, methods: methods})
+		}
+	}
+	if len(errs) > 0 {
+		serv.routeErrs = append(serv.routeErrs, errs...)
+		return
+	}
+
+	global := serv.middleHandlers(serv.globalMiddles())
 	for _, api := range apis {
 		api.SetApiErrorHandler(serv.errorHandler)
-		for _, h := range api.GetAPIs() {
-			if serv.authMid != nil {
-				serv.authMid.AddAuthPath(h.Path, h.Method, h.Auth, h.Group)
-			}
-			switch h.Method {
-			case "GET":
-				serv.Engine.GET(h.Path, append(serv.apiMids, h.Handler)...)
-			case "POST":
-				serv.Engine.POST(h.Path, append(serv.apiMids, h.Handler)...)
-			case "PUT":
-				serv.Engine.PUT(h.Path, append(serv.apiMids, h.Handler)...)
-			case "DELETE":
-				serv.Engine.DELETE(h.Path, append(serv.apiMids, h.Handler)...)
+	}
+	for _, r := range routes {
+		h := r.handler
+		handlers := make([]gin.HandlerFunc, 0, len(global)+len(r.middles)+1)
+		handlers = append(handlers, global...)
+		handlers = append(handlers, serv.middleHandlers(r.middles)...)
+		handlers = append(handlers, h.Handler)
+		for _, method := range r.methods {
+			if err := serv.handle(method, h.Path, handlers); err != nil {
+				serv.routeErrs = append(serv.routeErrs, newRouteError(r.api, method, h.Path, err))
+				continue
 			}
+			serv.addAuthPath(h, method)
 		}
+		serv.docRoutes = append(serv.docRoutes, docRoute{api: apiName(r.api), handler: h, methods: r.methods})
+	}
+	serv.openapiMu.Lock()
+	serv.openapiDoc = nil
+	serv.openapiMu.Unlock()
+}
+
+// gin 對路由衝突會 panic, 檢查沒攔到的情況轉成錯誤
+func (serv *ginApiServ) handle(method, path string, handlers []gin.HandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	serv.Engine.Handle(method, path, handlers...)
+	return nil
+}
+
+func (serv *ginApiServ) SetPerms(perms ...auth.ApiPerm) GinApiServer {
+	if serv.perms == nil {
+		serv.perms = make(map[auth.ApiPerm]bool)
+	}
+	for _, p := range perms {
+		serv.perms[p] = true
 	}
 	return serv
 }
 
+// 依實際註冊的 method 設定 auth, 與路由保持一致
+func (serv *ginApiServ) addAuthPath(h *GinApiHandler, method string) {
+	if serv.authMid == nil {
+		return
+	}
+	serv.authMid.AddAuthPath(h.Path, method, h.Auth, h.Group)
+	if routeMid, ok := serv.authMid.(auth.GinAuthRouteMidInter); ok {
+		routeMid.SetRouteOpts(h.Path, method, auth.RouteAuthOpts{
+			MfaMaxAge: h.MfaMaxAge,
+			Presign:   h.Presign,
+		})
+	}
+}
+
 func (serv *ginApiServ) SetTrustedProxies(proxies []string) GinApiServer {
 	if len(proxies) == 0 {
 		return serv
@@ -106,22 +286,47 @@ func (serv *ginApiServ) SetTrustedProxies(proxies []string) GinApiServer {
 }
 
 func (serv *ginApiServ) Run(port int) error {
+	if err := serv.Err(); err != nil {
+		return err
+	}
 	return serv.Engine.Run(":" + strconv.Itoa(port))
 }
 
+// 路由有錯誤時 panic, 需要處理錯誤時改用 GetServerE
 func (serv *ginApiServ) GetServer(port int) *http.Server {
+	server, err := serv.GetServerE(port)
+	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+func (serv *ginApiServ) GetServerE(port int) (*http.Server, error) {
+	if err := serv.Err(); err != nil {
+		return nil, err
+	}
 	return &http.Server{
 		Addr:    ":" + strconv.Itoa(port),
 		Handler: serv.Engine,
-	}
+	}, nil
 }
 
 func NewGinApiServer(mode string, service string) GinApiServer {
 	gin.SetMode(mode)
-	return &ginApiServ{
+	serv := &ginApiServ{
 		Engine:  gin.New(),
 		service: service,
 	}
+	serv.Engine.Use(serv.setErrorHandler)
+	return serv
+}
+
+// 讓 Handle 產生的 handler 取得 server 的 error handler
+func (serv *ginApiServ) setErrorHandler(c *gin.Context) {
+	if serv.myErrHandler != nil {
+		c.Set(ginKeyErrorHandler, errors.GinApiErrorHandler(serv.errorHandler))
+	}
+	c.Next()
 }
 
 func (serv *ginApiServ) SetPromhttp(c ...prometheus.Collector) GinApiServer {
@@ -0,0 +1,281 @@
+package apitool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/wayne011872/api-toolkit/auth"
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+const ginKeyErrorHandler = "api_toolkit_error_handler"
+
+type HandleOption func(*handleConf)
+
+type handleConf struct {
+	status int
+}
+
+// 成功時的 status, 預設 200; 204 時不輸出 body
+func HandleStatus(status int) HandleOption {
+	return func(conf *handleConf) {
+		conf.status = status
+	}
+}
+
+// 把 func(ctx, req) (resp, error) 轉成 GinApiHandler.Handler
+// 依序由 body (JSON 或 form)、query (form tag)、header (header tag)、path (uri tag) 綁定 Req, 後者覆蓋前者
+// query、header、path 只綁定明確帶有對應 tag 的欄位, 沒有 tag 的欄位只能由 body 設定
+// 綁定後以 binding tag 驗證, 不符合時回傳 errors.FieldErrors
+// ctx 帶有 auth.SetReqUserToCtx 放入的使用者, 錯誤交給 server 的 GinServerErrorHandler
+func Handle[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error), opts ...HandleOption) gin.HandlerFunc {
+	conf := handleConf{status: http.StatusOK}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	return func(c *gin.Context) {
+		var req Req
+		if err := bindRequest(c, &req); err != nil {
+			handleError(c, err)
+			return
+		}
+		ctx := c.Request.Context()
+		if user := auth.GetReqUserFromGin(c); user != nil {
+			ctx = auth.SetReqUserToCtx(ctx, user)
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+		if conf.status == http.StatusNoContent {
+			c.Status(conf.status)
+			return
+		}
+		c.JSON(conf.status, resp)
+	}
+}
+
+// Req 為指標型別時先配置
+func bindRequest(c *gin.Context, req interface{}) error {
+	target := reflect.ValueOf(req)
+	for target.Elem().Kind() == reflect.Pointer {
+		if target.Elem().IsNil() {
+			target.Elem().Set(reflect.New(target.Elem().Type().Elem()))
+		}
+		target = target.Elem()
+	}
+	obj := target.Interface()
+	if err := bindBody(c, obj); err != nil {
+		return errors.NewWithKey(http.StatusBadRequest, "invalid_request", err.Error())
+	}
+	if target.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	uri := map[string][]string{}
+	for _, p := range c.Params {
+		uri[p.Key] = []string{p.Value}
+	}
+	sources := map[string]map[string][]string{
+		"form":   c.Request.URL.Query(),
+		"header": c.Request.Header,
+		"uri":    uri,
+	}
+	if _, err := bindTagged(target.Elem(), sources); err != nil {
+		return errors.NewWithKey(http.StatusBadRequest, "invalid_request", err.Error())
+	}
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		if verrs, ok := err.(validator.ValidationErrors); ok {
+			return errors.NewValidationError(fieldErrors(target.Elem().Type(), verrs))
+		}
+		return errors.NewWithKey(http.StatusBadRequest, "invalid_request", err.Error())
+	}
+	return nil
+}
+
+// 依序由 query、header、path 綁定, 後者覆蓋前者
+var bindTags = []string{"form", "header", "uri"}
+
+// 只綁定明確帶有 form、header、uri tag 的欄位, 沒有 tag 的欄位只來自 body
+// 嵌入的 struct 一併處理, 回傳是否有欄位被設定
+func bindTagged(v reflect.Value, sources map[string]map[string][]string) (bool, error) {
+	t := v.Type()
+	isSet := false
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				ok, err := bindEmbedded(fv, sources)
+				if err != nil {
+					return false, err
+				}
+				isSet = isSet || ok
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		for _, tag := range bindTags {
+			ok, err := bindField(fv, f, tag, sources[tag])
+			if err != nil {
+				return false, err
+			}
+			isSet = isSet || ok
+		}
+	}
+	return isSet, nil
+}
+
+// 指標型別的嵌入 struct 有欄位被設定時才配置
+func bindEmbedded(fv reflect.Value, sources map[string]map[string][]string) (bool, error) {
+	if fv.Kind() != reflect.Pointer {
+		return bindTagged(fv, sources)
+	}
+	if !fv.IsNil() {
+		return bindTagged(fv.Elem(), sources)
+	}
+	if !fv.CanSet() {
+		return false, nil
+	}
+	nv := reflect.New(fv.Type().Elem())
+	ok, err := bindTagged(nv.Elem(), sources)
+	if ok {
+		fv.Set(nv)
+	}
+	return ok, err
+}
+
+// 以只有單一欄位的 struct 交給 binding 轉換型別, 保留 default、time_format 等選項
+// header 名稱與 gin 相同以 CanonicalMIMEHeaderKey 比對
+func bindField(fv reflect.Value, f reflect.StructField, tag string, form map[string][]string) (bool, error) {
+	name, opts, hasOpts := strings.Cut(f.Tag.Get(tag), ",")
+	if name == "" || name == "-" {
+		return false, nil
+	}
+	if tag == "header" {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+	}
+	if _, ok := form[name]; !ok && !strings.Contains(opts, "default=") {
+		return false, nil
+	}
+	if hasOpts {
+		name += "," + opts
+	}
+	holder := reflect.New(reflect.StructOf([]reflect.StructField{{
+		Name: "V",
+		Type: f.Type,
+		Tag:  reflect.StructTag(fmt.Sprintf("%s:%q %s", tag, name, f.Tag)),
+	}}))
+	holder.Elem().Field(0).Set(fv)
+	if err := binding.MapFormWithTag(holder.Interface(), form, tag); err != nil {
+		return false, err
+	}
+	fv.Set(holder.Elem().Field(0))
+	return true, nil
+}
+
+// 空 body 不算錯誤, 非 JSON 的 body 交給 ParserDataRequest (form)
+func bindBody(c *gin.Context, obj interface{}) error {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil
+	}
+	if ct := c.ContentType(); ct != "" && ct != binding.MIMEJSON {
+		return ParserDataRequest(c.Request, obj)
+	}
+	err := json.NewDecoder(c.Request.Body).Decode(obj)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// 依欄位的 tag 決定錯誤位置與名稱, body 內的欄位以 json 名稱組成路徑
+func fieldErrors(t reflect.Type, verrs validator.ValidationErrors) []errors.FieldError {
+	fields := make([]errors.FieldError, len(verrs))
+	for i, fe := range verrs {
+		name, in := fieldLocation(t, fe.StructNamespace())
+		msg := "required"
+		switch {
+		case fe.Tag() == "required":
+		case fe.Param() != "":
+			msg = fmt.Sprintf("must satisfy %s=%s", fe.Tag(), fe.Param())
+		default:
+			msg = "must satisfy " + fe.Tag()
+		}
+		fields[i] = errors.FieldError{Field: name, In: in, Message: msg}
+	}
+	return fields
+}
+
+// ns 例如 CreateReq.Items[0].Name, 第一段為型別名稱
+func fieldLocation(t reflect.Type, ns string) (string, string) {
+	parts := strings.Split(ns, ".")[1:]
+	in := errors.InBody
+	var path []string
+	for depth, part := range parts {
+		fieldName, index, _ := strings.Cut(part, "[")
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		f, ok := t.FieldByName(fieldName)
+		if !ok || t.Kind() != reflect.Struct {
+			path = append(path, part)
+			continue
+		}
+		name := ""
+		if depth == 0 {
+			for _, loc := range []struct{ tag, in string }{{"uri", errors.InPath}, {"form", errors.InQuery}, {"header", errors.InHeader}} {
+				if v, _, _ := strings.Cut(f.Tag.Get(loc.tag), ","); v != "" && v != "-" {
+					name, in = v, loc.in
+					break
+				}
+			}
+		}
+		if name == "" {
+			name, _, _ = strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				name = f.Name
+			}
+		}
+		if index != "" {
+			name += "[" + index
+		}
+		path = append(path, name)
+		t = f.Type
+	}
+	return strings.Join(path, "."), in
+}
+
+// server 在每個請求放入自己的 error handler, 不是經由 server 註冊的路由改用預設格式
+func handleError(c *gin.Context, err error) {
+	if h, ok := c.Get(ginKeyErrorHandler); ok {
+		if handler, ok := h.(errors.GinApiErrorHandler); ok {
+			handler(c, err)
+			c.Abort()
+			return
+		}
+	}
+	// 不是 ApiError 的錯誤可能帶有內部細節, 只回傳通用訊息
+	body := ErrorBody{Status: http.StatusInternalServerError, Error: http.StatusText(http.StatusInternalServerError)}
+	if apiErr, ok := err.(errors.ApiError); ok {
+		body.Status, body.Error, body.ErrorKey = apiErr.GetStatus(), apiErr.Error(), apiErr.GetKey()
+	}
+	if fieldErr, ok := err.(errors.FieldErrors); ok {
+		body.Fields = fieldErr.GetFields()
+	}
+	c.AbortWithStatusJSON(body.Status, body)
+}
@@ -0,0 +1,219 @@
+package apitool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+type Paging struct {
+	Page int `form:"page,default=1"`
+}
+
+type bindReq struct {
+	Paging
+	Id     string `uri:"id"`
+	Tenant string `header:"x-tenant"`
+	Role   string `json:"role"`
+	Name   string `json:"name" binding:"required"`
+}
+
+func TestHandleBindsOnlyTaggedFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var got bindReq
+	r.POST("/users/:id", Handle(func(ctx context.Context, req bindReq) (bindReq, error) {
+		got = req
+		return req, nil
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/users/u1?Role=admin&role=admin&Name=x", strings.NewReader(`{"name":"amy"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Role", "admin")
+	req.Header.Set("X-Tenant", "t1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	want := bindReq{Paging: Paging{Page: 1}, Id: "u1", Tenant: "t1", Name: "amy"}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestHandleUntaggedFieldNotFromQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/users/:id", Handle(func(ctx context.Context, req bindReq) (bindReq, error) {
+		return req, nil
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/users/u1?Name=amy&page=2", http.NoBody)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var body ErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Fields) != 1 || body.Fields[0].Field != "name" {
+		t.Fatalf("fields %+v", body.Fields)
+	}
+}
+
+type locationItem struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type locationReq struct {
+	Id     string         `uri:"id" binding:"len=3"`
+	Limit  int            `form:"limit" binding:"max=10"`
+	Tenant string         `header:"x-tenant" binding:"required"`
+	Items  []locationItem `json:"items" binding:"dive"`
+}
+
+func TestHandleValidationFieldErrors(t *testing.T) {
+	var gotErr error
+	api := &groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{
+		{Path: "/users/:id", Method: "POST", Handler: Handle(func(ctx context.Context, req locationReq) (locationReq, error) {
+			t.Error("handler called with an invalid request")
+			return req, nil
+		})},
+	}}}
+	server, err := NewGinApiServer(gin.TestMode, "svc").
+		SetServerErrorHandler(func(c *gin.Context, service string, err error) {
+			gotErr = err
+			c.AbortWithStatus(err.(errors.ApiError).GetStatus())
+		}).
+		AddAPIs(api).GetServerE(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/users/u1?limit=11", strings.NewReader(`{"items":[{"name":"a"},{}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	fieldErr, ok := gotErr.(errors.FieldErrors)
+	if !ok {
+		t.Fatalf("error %T is not errors.FieldErrors", gotErr)
+	}
+	got := map[string]string{}
+	for _, f := range fieldErr.GetFields() {
+		got[f.In+" "+f.Field] = f.Message
+	}
+	want := map[string]string{
+		"path id":            "must satisfy len=3",
+		"query limit":        "must satisfy max=10",
+		"header x-tenant":    "required",
+		"body items[1].name": "required",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("fields %v, want %v", got, want)
+	}
+}
+
+func TestHandleServerErrorHandler(t *testing.T) {
+	wantErr := errors.NewWithKey(http.StatusConflict, "conflict", "already exists")
+	var gotErr error
+	var gotService string
+	api := &groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{
+		{Path: "/users", Method: "POST", Handler: Handle(func(ctx context.Context, req struct{}) (struct{}, error) {
+			return struct{}{}, wantErr
+		})},
+	}}}
+	server, err := NewGinApiServer(gin.TestMode, "svc").
+		SetServerErrorHandler(func(c *gin.Context, service string, err error) {
+			gotErr, gotService = err, service
+			c.String(http.StatusTeapot, "handled")
+		}).
+		AddAPIs(api).GetServerE(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users", http.NoBody))
+	if w.Code != http.StatusTeapot || w.Body.String() != "handled" {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if gotErr != wantErr || gotService != "svc" {
+		t.Fatalf("handler got %v from %q", gotErr, gotService)
+	}
+}
+
+func TestHandleStatusNoContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.DELETE("/users/:id", Handle(func(ctx context.Context, req bindReq) (*bindReq, error) {
+		return &req, nil
+	}, HandleStatus(http.StatusNoContent)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/users/u1", strings.NewReader(`{"name":"amy"}`)))
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("status %d: %q", w.Code, w.Body)
+	}
+}
+
+func TestHandlePointerReq(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	var got *bindReq
+	r.POST("/users/:id", Handle(func(ctx context.Context, req *bindReq) (*bindReq, error) {
+		got = req
+		return req, nil
+	}, HandleStatus(http.StatusCreated)))
+
+	req := httptest.NewRequest(http.MethodPost, "/users/u1?page=3", strings.NewReader(`{"name":"amy"}`))
+	req.Header.Set("X-Tenant", "t1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	want := bindReq{Paging: Paging{Page: 3}, Id: "u1", Tenant: "t1", Name: "amy"}
+	if got == nil || *got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users/u1", http.NoBody))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("pointer req not validated: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestHandleErrorHidesInternalError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/users", Handle(func(ctx context.Context, req struct{}) (struct{}, error) {
+		return struct{}{}, fmt.Errorf("dial tcp 10.0.0.1:5432: password authentication failed")
+	}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", http.NoBody))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var body ErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != http.StatusText(http.StatusInternalServerError) || strings.Contains(w.Body.String(), "10.0.0.1") {
+		t.Fatalf("body %s", w.Body)
+	}
+}
@@ -0,0 +1,312 @@
+package apitool
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/errors"
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+// 產生 OpenAPI 文件用的 handler 說明, 型別欄位傳入零值即可, 例如 Request: CreateUserReq{}
+type ApiDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// 預設由 method 與 path 產生, 例如 GET /v1/users/:id 為 getV1UsersById
+	OperationId string
+	Deprecated  bool
+	// 不列入 OpenAPI 文件
+	Hidden bool
+	// path 參數 struct (uri tag), 未列出的 path 參數視為字串
+	Params interface{}
+	// query 參數 struct (form tag)
+	Query interface{}
+	// header 參數 struct (header tag)
+	Header interface{}
+	// JSON 請求 body
+	Request interface{}
+	// 成功回應的 JSON body 與 status, status 預設 200
+	Response interface{}
+	Status   int
+	// 可能回傳的錯誤, 依 status 合併成回應
+	Errors []errors.ApiError
+}
+
+type OpenAPIConf struct {
+	// 預設 /openapi.json
+	Path    string
+	Info    openapi.Info
+	Servers []openapi.Server
+	// 錯誤回應的 body 型別, 預設 ErrorBody
+	ErrorBody interface{}
+	// 每個 operation 加上 default 錯誤回應, 涵蓋 Errors 沒有列出的 status (例如 panic 時的 500)
+	DefaultErrorResponse bool
+}
+
+// 與 _example 的 server error handler 輸出格式相同
+type ErrorBody struct {
+	Status   int    `json:"status"`
+	Error    string `json:"error"`
+	Service  string `json:"service"`
+	ErrorKey string `json:"errorKey"`
+	// errors.FieldErrors 的欄位錯誤
+	Fields []errors.FieldError `json:"fields,omitempty"`
+}
+
+const defaultOpenAPIPath = "/openapi.json"
+
+type docRoute struct {
+	api     string
+	handler *GinApiHandler
+	methods []string
+}
+
+// 不含套件與指標的型別名稱, 例如 *user.userAPI 為 userAPI
+func apiName(api GinAPI) string {
+	t := reflect.TypeOf(api)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func (serv *ginApiServ) ServeOpenAPI(conf OpenAPIConf) GinApiServer {
+	if conf.Path == "" {
+		conf.Path = defaultOpenAPIPath
+	}
+	serv.openapiConf = &conf
+	return serv
+}
+
+func (serv *ginApiServ) OpenAPI() *openapi.Document {
+	serv.build()
+	serv.openapiMu.Lock()
+	defer serv.openapiMu.Unlock()
+	if serv.openapiDoc == nil {
+		conf := OpenAPIConf{}
+		if serv.openapiConf != nil {
+			conf = *serv.openapiConf
+		}
+		if conf.Info.Title == "" {
+			conf.Info.Title = serv.service
+		}
+		if conf.Info.Version == "" {
+			conf.Info.Version = "1.0.0"
+		}
+		serv.openapiDoc = newOpenAPIDocument(conf, serv.docRoutes)
+	}
+	return serv.openapiDoc
+}
+
+func (serv *ginApiServ) openAPIHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, serv.OpenAPI())
+}
+
+func newOpenAPIDocument(conf OpenAPIConf, routes []docRoute) *openapi.Document {
+	g := openapi.NewGenerator()
+	// 最先註冊, 名稱固定為 FieldError, 不會被同名的其他型別佔用
+	g.SchemaOf(errors.FieldError{})
+	errorBody := conf.ErrorBody
+	if errorBody == nil {
+		errorBody = ErrorBody{}
+	}
+	errSchema := g.SchemaOf(errorBody)
+	doc := &openapi.Document{
+		OpenAPI:    openapi.Version,
+		Info:       conf.Info,
+		Servers:    conf.Servers,
+		Paths:      map[string]*openapi.PathItem{},
+		Components: &openapi.Components{},
+	}
+	for _, r := range routes {
+		if r.handler.Doc != nil && r.handler.Doc.Hidden {
+			continue
+		}
+		p, pathParams := toOpenAPIPath(r.handler.Path)
+		for _, method := range r.methods {
+			m := strings.ToLower(method)
+			if !openapi.IsOperationMethod(m) {
+				continue
+			}
+			op := newOperation(g, r.handler, method, pathParams, errSchema)
+			op.Api = r.api
+			if conf.DefaultErrorResponse {
+				op.Responses[openapi.DefaultResponse] = &openapi.Response{
+					Description: "Unexpected error",
+					Content:     openapi.JSONContent(errSchema),
+				}
+			}
+			if len(r.methods) > 1 && r.handler.Doc != nil && r.handler.Doc.OperationId != "" {
+				op.OperationId += exportName(m)
+			}
+			if r.handler.Auth {
+				if doc.Components.SecuritySchemes == nil {
+					doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+						openapi.BearerSchemeName: openapi.NewBearerScheme(),
+					}
+				}
+			}
+			item := doc.Paths[p]
+			if item == nil {
+				item = &openapi.PathItem{}
+				doc.Paths[p] = item
+			}
+			(*item)[m] = op
+		}
+	}
+	doc.Components.Schemas = g.Schemas()
+	return doc
+}
+
+func newOperation(g *openapi.Generator, h *GinApiHandler, method string, pathParams []string, errSchema *openapi.Schema) *openapi.Operation {
+	d := h.Doc
+	if d == nil {
+		d = &ApiDoc{}
+	}
+	op := &openapi.Operation{
+		OperationId: d.OperationId,
+		Summary:     d.Summary,
+		Description: d.Description,
+		Tags:        d.Tags,
+		Deprecated:  d.Deprecated,
+		Responses:   map[string]*openapi.Response{},
+	}
+	if op.OperationId == "" {
+		op.OperationId = operationId(method, h.Path)
+	}
+
+	declared := map[string]*openapi.Parameter{}
+	for _, p := range g.Parameters(d.Params, openapi.InPath) {
+		declared[p.Name] = p
+	}
+	for _, name := range pathParams {
+		p, ok := declared[name]
+		if !ok {
+			p = &openapi.Parameter{Name: name, In: openapi.InPath, Required: true, Schema: &openapi.Schema{Type: "string"}}
+		}
+		op.Parameters = append(op.Parameters, p)
+	}
+	op.Parameters = append(op.Parameters, g.Parameters(d.Query, openapi.InQuery)...)
+	op.Parameters = append(op.Parameters, g.Parameters(d.Header, openapi.InHeader)...)
+
+	if d.Request != nil {
+		op.RequestBody = &openapi.RequestBody{
+			Required: true,
+			Content:  openapi.JSONContent(g.SchemaOf(d.Request)),
+		}
+	}
+
+	status := d.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := &openapi.Response{Description: http.StatusText(status)}
+	if d.Response != nil {
+		resp.Content = openapi.JSONContent(g.SchemaOf(d.Response))
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+
+	errDesc := map[int][]string{}
+	for _, e := range d.Errors {
+		errDesc[e.GetStatus()] = append(errDesc[e.GetStatus()], errorDescription(e))
+	}
+	if h.Auth {
+		op.Security = []openapi.SecurityRequirement{{openapi.BearerSchemeName: groupStrings(h)}}
+		if _, ok := errDesc[http.StatusUnauthorized]; !ok {
+			errDesc[http.StatusUnauthorized] = []string{http.StatusText(http.StatusUnauthorized)}
+		}
+		// 權限不足時 auth middleware 回應 Error_Auth_No_Perm, 狀態碼以它為準
+		if noPerm := errors.Error_Auth_No_Perm; len(h.Group) > 0 {
+			desc := errorDescription(noPerm)
+			if !containsString(errDesc[noPerm.GetStatus()], desc) {
+				errDesc[noPerm.GetStatus()] = append(errDesc[noPerm.GetStatus()], desc)
+			}
+		}
+	}
+	for s, descs := range errDesc {
+		sort.Strings(descs)
+		op.Responses[strconv.Itoa(s)] = &openapi.Response{
+			Description: strings.Join(descs, "; "),
+			Content:     openapi.JSONContent(errSchema),
+		}
+	}
+	return op
+}
+
+func containsString(ary []string, s string) bool {
+	for _, v := range ary {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func errorDescription(e errors.ApiError) string {
+	if e.GetKey() != "" {
+		return e.GetKey() + ": " + e.Error()
+	}
+	return e.Error()
+}
+
+// 非 nil 的空陣列, 沒有 Group 時輸出 []
+func groupStrings(h *GinApiHandler) []string {
+	perms := make([]string, len(h.Group))
+	for i, p := range h.Group {
+		perms[i] = string(p)
+	}
+	return perms
+}
+
+// gin 的 :id 與 *path 轉成 {id} 與 {path}
+func toOpenAPIPath(p string) (string, []string) {
+	segs := strings.Split(p, "/")
+	var params []string
+	for i, seg := range segs {
+		if seg != "" && (seg[0] == ':' || seg[0] == '*') {
+			params = append(params, seg[1:])
+			segs[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/"), params
+}
+
+func operationId(method, p string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "" {
+			continue
+		}
+		if seg[0] == ':' || seg[0] == '*' {
+			b.WriteString("By")
+			seg = seg[1:]
+		}
+		b.WriteString(exportName(seg))
+	}
+	return b.String()
+}
+
+// 以非英數字元分段, 每段首字大寫後接起來
+func exportName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,113 @@
+// openapi 定義 OpenAPI 3.1 文件結構, 並以 reflection 由 Go 型別產生 JSON Schema
+package openapi
+
+const (
+	Version = "3.1.0"
+
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+
+	BearerSchemeName = "bearerAuth"
+	MimeJSON         = "application/json"
+	// Operation.Responses 中涵蓋其他 status 的回應
+	DefaultResponse = "default"
+	// apitool 產生的文件中 errors.FieldError 的 $ref, client 產生器以此沿用內建型別
+	FieldErrorRef = refPrefix + "FieldError"
+)
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []Server             `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components *Components          `json:"components,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// key 為小寫 method, 只有 OpenAPI 定義的 method 會出現
+type PathItem map[string]*Operation
+
+var operationMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// OpenAPI 3.1 的 Path Item 可描述的 method
+func IsOperationMethod(method string) bool {
+	return operationMethods[method]
+}
+
+type Operation struct {
+	OperationId string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	// 註冊此路由的 GinAPI 型別名稱, client 產生器以此分組
+	Api string `json:"x-gin-api,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// key 為 security scheme 名稱, 值為所需權限 (bearer 以 GinApiHandler.Group 填入)
+type SecurityRequirement map[string][]string
+
+func NewBearerScheme() *SecurityScheme {
+	return &SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+	}
+}
+
+func JSONContent(s *Schema) map[string]*MediaType {
+	return map[string]*MediaType{MimeJSON: {Schema: s}}
+}
@@ -0,0 +1,397 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	// json tag 有 omitempty, 零值時不會出現在 JSON 中
+	OmitEmpty bool `json:"x-omitempty,omitempty"`
+	// 值可為 null, 輸出為 type: [T, "null"], $ref 則以 anyOf 加上 null
+	Nullable bool `json:"-"`
+}
+
+type schemaJSON Schema
+
+var nullSchema = &Schema{Type: "null"}
+
+func (s Schema) MarshalJSON() ([]byte, error) {
+	switch {
+	case !s.Nullable:
+	case s.Ref != "":
+		return json.Marshal(struct {
+			schemaJSON
+			Ref   string    `json:"$ref,omitempty"`
+			AnyOf []*Schema `json:"anyOf"`
+		}{schemaJSON: schemaJSON(s), AnyOf: []*Schema{{Ref: s.Ref}, nullSchema}})
+	case s.Type != "":
+		return json.Marshal(struct {
+			schemaJSON
+			Type []string `json:"type"`
+		}{schemaJSON: schemaJSON(s), Type: []string{s.Type, "null"}})
+	}
+	return json.Marshal(schemaJSON(s))
+}
+
+// 只還原 MarshalJSON 產生的兩種 nullable 寫法
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		schemaJSON
+		Type  json.RawMessage `json:"type"`
+		AnyOf []*Schema       `json:"anyOf"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*s = Schema(raw.schemaJSON)
+	if len(raw.Type) > 0 {
+		var types []string
+		if err := json.Unmarshal(raw.Type, &types); err != nil {
+			types = []string{""}
+			if err = json.Unmarshal(raw.Type, &types[0]); err != nil {
+				return err
+			}
+		}
+		for _, t := range types {
+			if t == "null" && len(types) > 1 {
+				s.Nullable = true
+				continue
+			}
+			s.Type = t
+		}
+	}
+	if len(raw.AnyOf) == 2 && s.Ref == "" && s.Type == "" {
+		for i, a := range raw.AnyOf {
+			if other := raw.AnyOf[1-i]; a.Type == "null" && other.Ref != "" {
+				s.Ref, s.Nullable = other.Ref, true
+			}
+		}
+	}
+	return nil
+}
+
+const refPrefix = "#/components/schemas/"
+
+func RefName(ref string) string {
+	return strings.TrimPrefix(ref, refPrefix)
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	invalidNameChar   = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+// 以 reflection 產生 schema, 具名 struct 放進 components 並以 $ref 參照
+// 欄位名稱依 json tag, 驗證規則取自 gin 的 binding tag, 說明取自 description tag
+type Generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		schemas: make(map[string]*Schema),
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+func (g *Generator) Schemas() map[string]*Schema {
+	return g.schemas
+}
+
+// 傳入值或型別的零值, nil 回傳 nil
+func (g *Generator) SchemaOf(v interface{}) *Schema {
+	if v == nil {
+		return nil
+	}
+	if t, ok := v.(reflect.Type); ok {
+		return g.Schema(t)
+	}
+	return g.Schema(reflect.TypeOf(v))
+}
+
+// 與 encoding/json 相同, nil 的指標、slice 與 map 標為 nullable
+func (g *Generator) Schema(t reflect.Type) *Schema {
+	s := g.schema(t)
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map:
+		s.Nullable = true
+	}
+	return s
+}
+
+func (g *Generator) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return &Schema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.Schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.Schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return &Schema{Ref: refPrefix + g.register(t)}
+	}
+	// interface 與其他型別不限制
+	return &Schema{}
+}
+
+// 先佔用名稱再展開欄位, 遞迴型別才不會無窮展開
+func (g *Generator) register(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := invalidNameChar.ReplaceAllString(t.Name(), "_")
+	if _, used := g.schemas[name]; used {
+		pkg := t.PkgPath()
+		name = invalidNameChar.ReplaceAllString(pkg[strings.LastIndex(pkg, "/")+1:], "_") + "." + name
+		for i, base := 2, name; ; i++ {
+			if _, used = g.schemas[name]; !used {
+				break
+			}
+			name = base + strconv.Itoa(i)
+		}
+	}
+	g.names[t] = name
+	g.schemas[name] = &Schema{}
+	*g.schemas[name] = *g.structSchema(t)
+	return name
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	g.addFields(s, t)
+	return s
+}
+
+// 與 encoding/json 相同, 沒有 json 名稱的嵌入 struct 會攤平
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := g.Schema(f.Type)
+		if strings.Contains(","+opts+",", ",string,") {
+			fs = &Schema{Type: "string", Nullable: fs.Nullable}
+		}
+		fs.Description = f.Tag.Get("description")
+		fs.OmitEmpty = strings.Contains(","+opts+",", ",omitempty,")
+		// omitempty 會省略 nil, 不會輸出 null
+		if fs.OmitEmpty {
+			fs.Nullable = false
+		}
+		if ApplyBinding(fs, f.Tag.Get("binding")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// 依 binding tag (go-playground/validator 語法) 設定限制, 回傳是否為 required
+// 遇到 dive 之後的規則屬於元素, 不再處理
+func ApplyBinding(s *Schema, binding string) (required bool) {
+	if binding == "" {
+		return false
+	}
+	for _, rule := range strings.Split(binding, ",") {
+		key, val, _ := strings.Cut(rule, "=")
+		switch key {
+		case "dive":
+			return
+		case "required":
+			required = true
+		case "email":
+			s.Format = "email"
+		case "url", "uri":
+			s.Format = "uri"
+		case "uuid", "uuid4":
+			s.Format = "uuid"
+		case "oneof":
+			for _, v := range strings.Fields(val) {
+				s.Enum = append(s.Enum, enumValue(s.Type, v))
+			}
+		case "len":
+			applyBound(s, val, true, true)
+		case "min", "gte":
+			applyBound(s, val, true, false)
+		case "max", "lte":
+			applyBound(s, val, false, true)
+		case "gt":
+			if n, err := strconv.ParseFloat(val, 64); err == nil && isNumber(s) {
+				s.ExclusiveMinimum = &n
+			}
+		case "lt":
+			if n, err := strconv.ParseFloat(val, 64); err == nil && isNumber(s) {
+				s.ExclusiveMaximum = &n
+			}
+		}
+	}
+	return
+}
+
+func isNumber(s *Schema) bool {
+	return s.Type == "integer" || s.Type == "number"
+}
+
+func applyBound(s *Schema, val string, min, max bool) {
+	n, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return
+	}
+	i := int(n)
+	switch {
+	case isNumber(s):
+		if min {
+			s.Minimum = &n
+		}
+		if max {
+			s.Maximum = &n
+		}
+	case s.Type == "string":
+		if min {
+			s.MinLength = &i
+		}
+		if max {
+			s.MaxLength = &i
+		}
+	case s.Type == "array":
+		if min {
+			s.MinItems = &i
+		}
+		if max {
+			s.MaxItems = &i
+		}
+	}
+}
+
+func enumValue(typ, v string) interface{} {
+	switch typ {
+	case "integer":
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+	}
+	return v
+}
+
+// 由 struct 欄位產生參數, tag 依 gin 的綁定方式: path 為 uri, query 為 form, header 為 header
+func (g *Generator) Parameters(v interface{}, in string) []*Parameter {
+	if v == nil {
+		return nil
+	}
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	tagKey := map[string]string{InPath: "uri", InQuery: "form", InHeader: "header"}[in]
+	var params []*Parameter
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Tag.Get(tagKey) == "" {
+			params = append(params, g.Parameters(f.Type, in)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get(tagKey), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		// 參數只有字串值, 不會是 null
+		s := g.schema(f.Type)
+		p := &Parameter{
+			Name:        name,
+			In:          in,
+			Description: f.Tag.Get("description"),
+			Schema:      s,
+		}
+		p.Required = ApplyBinding(s, f.Tag.Get("binding")) || in == InPath
+		params = append(params, p)
+	}
+	return params
+}
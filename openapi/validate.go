@@ -0,0 +1,313 @@
+package openapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 值不符合 schema 的位置與原因, Field 例如 items[0].name, 根節點為空字串
+type Violation struct {
+	Field   string
+	Message string
+}
+
+var (
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	patternMu   sync.Mutex
+	patterns    = map[string]*regexp.Regexp{}
+)
+
+// 驗證 encoding/json 解出的值 (數字可為 float64 或 json.Number)
+// 只支援 Generator 會產生的關鍵字, $ref 由 Components.Schemas 解析
+// 與 Go 的 JSON 輸出一致, 非 required 的屬性允許為 null
+type Validator struct {
+	schemas map[string]*Schema
+}
+
+func NewValidator(doc *Document) *Validator {
+	v := &Validator{}
+	if doc != nil && doc.Components != nil {
+		v.schemas = doc.Components.Schemas
+	}
+	return v
+}
+
+func (v *Validator) Validate(s *Schema, value interface{}) []Violation {
+	var out []Violation
+	v.validate(s, value, "", &out)
+	return out
+}
+
+// query/header/path 參數的原始字串依 schema 型別轉換後驗證, 陣列參數每個值為一個元素
+func (v *Validator) ValidateParam(p *Parameter, values []string) []Violation {
+	if len(values) == 0 {
+		if p.Required {
+			return []Violation{{Field: p.Name, Message: "required"}}
+		}
+		return nil
+	}
+	s := v.resolve(p.Schema)
+	if s == nil {
+		return nil
+	}
+	var out []Violation
+	if s.Type == "array" {
+		items := make([]interface{}, 0, len(values))
+		for i, raw := range values {
+			val, err := parseParam(v.resolve(s.Items), raw)
+			if err != nil {
+				out = append(out, Violation{Field: fmt.Sprintf("%s[%d]", p.Name, i), Message: err.Error()})
+				continue
+			}
+			items = append(items, val)
+		}
+		if len(out) > 0 {
+			return out
+		}
+		v.validate(s, items, p.Name, &out)
+		return out
+	}
+	val, err := parseParam(s, values[0])
+	if err != nil {
+		return []Violation{{Field: p.Name, Message: err.Error()}}
+	}
+	v.validate(s, val, p.Name, &out)
+	return out
+}
+
+func parseParam(s *Schema, raw string) (interface{}, error) {
+	if s == nil {
+		return raw, nil
+	}
+	switch s.Type {
+	case "integer", "number":
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be %s", article(s.Type))
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	}
+	return raw, nil
+}
+
+func (v *Validator) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = v.schemas[RefName(s.Ref)]
+	}
+	return s
+}
+
+func (v *Validator) validate(s *Schema, value interface{}, field string, out *[]Violation) {
+	if value == nil && s != nil && s.Nullable {
+		return
+	}
+	s = v.resolve(s)
+	if s == nil {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*out = append(*out, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.Type != "" && !matchType(s.Type, value) {
+		fail("must be %s", article(s.Type))
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		opts := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			opts[i] = fmt.Sprint(e)
+		}
+		fail("must be one of [%s]", strings.Join(opts, " "))
+	}
+	switch val := value.(type) {
+	case string:
+		v.validateString(s, val, fail)
+	case float64, json.Number:
+		n, _ := toFloat(val)
+		if s.Format == "int32" && (n < math.MinInt32 || n > math.MaxInt32) {
+			fail("out of int32 range")
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("must be <= %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+			fail("must be > %v", *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+			fail("must be < %v", *s.ExclusiveMaximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range val {
+			v.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i), out)
+		}
+	case map[string]interface{}:
+		v.validateObject(s, val, field, out)
+	}
+}
+
+func (v *Validator) validateString(s *Schema, val string, fail func(string, ...interface{})) {
+	n := utf8.RuneCountInString(val)
+	if s.MinLength != nil && n < *s.MinLength {
+		fail("length must be >= %d", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		fail("length must be <= %d", *s.MaxLength)
+	}
+	if s.Pattern != "" {
+		if re := compilePattern(s.Pattern); re != nil && !re.MatchString(val) {
+			fail("must match pattern %s", s.Pattern)
+		}
+	}
+	if s.Format != "" && !matchFormat(s.Format, val) {
+		fail("must be a valid %s", s.Format)
+	}
+}
+
+func (v *Validator) validateObject(s *Schema, val map[string]interface{}, field string, out *[]Violation) {
+	for _, name := range s.Required {
+		if pv, ok := val[name]; !ok || pv == nil {
+			*out = append(*out, Violation{Field: joinField(field, name), Message: "required"})
+		}
+	}
+	keys := make([]string, 0, len(val))
+	for k := range val {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pv := val[k]
+		if pv == nil {
+			continue
+		}
+		if ps, ok := s.Properties[k]; ok {
+			v.validate(ps, pv, joinField(field, k), out)
+		} else if s.AdditionalProperties != nil {
+			v.validate(s.AdditionalProperties, pv, joinField(field, k), out)
+		}
+	}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func matchType(typ string, value interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := toFloat(value)
+		return ok
+	case "integer":
+		n, ok := toFloat(value)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	}
+	return true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// 數字的 enum 以數值比較, json.Number 與 int64 才會相等
+func inEnum(enum []interface{}, value interface{}) bool {
+	n, isNum := toFloat(value)
+	for _, e := range enum {
+		if isNum {
+			if en, err := strconv.ParseFloat(fmt.Sprint(e), 64); err == nil && en == n {
+				return true
+			}
+			continue
+		}
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFormat(format, val string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, val)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(val)
+		return err == nil && addr.Address == val
+	case "uuid":
+		return uuidPattern.MatchString(val)
+	case "uri":
+		u, err := url.Parse(val)
+		return err == nil && u.Scheme != ""
+	case "byte":
+		_, err := base64.StdEncoding.DecodeString(val)
+		return err == nil
+	}
+	return true
+}
+
+// pattern 來自程式內的 tag, 編譯失敗時略過
+func compilePattern(p string) *regexp.Regexp {
+	patternMu.Lock()
+	defer patternMu.Unlock()
+	re, ok := patterns[p]
+	if !ok {
+		re, _ = regexp.Compile(p)
+		patterns[p] = re
+	}
+	return re
+}
+
+func article(typ string) string {
+	switch typ {
+	case "integer", "object", "array":
+		return "an " + typ
+	}
+	return "a " + typ
+}
@@ -0,0 +1,143 @@
+package apitool
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/auth"
+	"github.com/wayne011872/api-toolkit/codegen"
+	"github.com/wayne011872/api-toolkit/errors"
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+func TestOpenAPIGroupPermStatus(t *testing.T) {
+	noop := func(c *gin.Context) {}
+	api := &groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{
+		{Path: "/admin", Method: "GET", Handler: noop, Auth: true, Group: []auth.ApiPerm{"admin"}},
+	}}}
+	doc := NewGinApiServer(gin.TestMode, "svc").AddAPIs(api).OpenAPI()
+	op := (*doc.Paths["/admin"])["get"]
+	if op == nil {
+		t.Fatal("missing operation")
+	}
+	status := strconv.Itoa(errors.Error_Auth_No_Perm.GetStatus())
+	if r := op.Responses[status]; r == nil || r.Description != "Unauthorized; no permission" {
+		t.Fatalf("response %s: %+v", status, r)
+	}
+	if status != strconv.Itoa(http.StatusForbidden) && op.Responses[strconv.Itoa(http.StatusForbidden)] != nil {
+		t.Fatal("403 documented but the middleware never sends it")
+	}
+}
+
+type nullableItem struct {
+	Name string `json:"name"`
+}
+
+type nullableResp struct {
+	Item  *nullableItem     `json:"item"`
+	Count *int              `json:"count"`
+	Tags  []string          `json:"tags"`
+	Meta  map[string]string `json:"meta,omitempty"`
+	Name  string            `json:"name"`
+}
+
+func TestSchemaNullable(t *testing.T) {
+	g := openapi.NewGenerator()
+	s := g.SchemaOf(nullableResp{})
+	b, err := json.Marshal(g.Schemas()[openapi.RefName(s.Ref)])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	if err = json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for prop, want := range map[string]string{
+		"item":  `[{"$ref":"#/components/schemas/nullableItem"},{"type":"null"}]`,
+		"count": `["integer","null"]`,
+		"tags":  `["array","null"]`,
+		"meta":  `"object"`,
+		"name":  `"string"`,
+	} {
+		key := "type"
+		if prop == "item" {
+			key = "anyOf"
+		}
+		if got, _ := json.Marshal(raw.Properties[prop][key]); string(got) != want {
+			t.Errorf("%s.%s = %s, want %s", prop, key, got, want)
+		}
+	}
+
+	var back openapi.Schema
+	if err = json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	if p := back.Properties["item"]; !p.Nullable || p.Ref == "" {
+		t.Errorf("item after round trip: %+v", p)
+	}
+	if p := back.Properties["count"]; !p.Nullable || p.Type != "integer" {
+		t.Errorf("count after round trip: %+v", p)
+	}
+	if p := back.Properties["name"]; p.Nullable {
+		t.Errorf("name after round trip: %+v", p)
+	}
+
+	v := openapi.NewValidator(&openapi.Document{Components: &openapi.Components{Schemas: g.Schemas()}})
+	if errs := v.Validate(g.SchemaOf([]*nullableItem{}), []interface{}{nil}); len(errs) > 0 {
+		t.Errorf("null item rejected: %v", errs)
+	}
+	if errs := v.Validate(g.SchemaOf([]nullableItem{}), []interface{}{nil}); len(errs) == 0 {
+		t.Error("null item of a non-pointer slice accepted")
+	}
+}
+
+func TestTypeScriptNullable(t *testing.T) {
+	noop := func(c *gin.Context) {}
+	api := &groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{
+		{Path: "/item", Method: "GET", Handler: noop, Doc: &ApiDoc{Response: nullableResp{}}},
+	}}}
+	doc := NewGinApiServer(gin.TestMode, "svc").AddAPIs(api).OpenAPI()
+	ts := string(codegen.TypeScriptClient(doc))
+	for _, want := range []string{"item: NullableItem | null;", "count: number | null;", "tags: string[] | null;", "meta?: Record<string, string>;", "name: string;"} {
+		if !strings.Contains(ts, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+// 與 errors.FieldError 同名的型別
+type FieldError struct {
+	Code int `json:"code"`
+}
+
+type customErrorBody struct {
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields"`
+}
+
+func TestOpenAPIFieldErrorRef(t *testing.T) {
+	noop := func(c *gin.Context) {}
+	api := &groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{
+		{Path: "/item", Method: "GET", Handler: noop, Doc: &ApiDoc{Response: nullableResp{}}},
+	}}}
+	doc := NewGinApiServer(gin.TestMode, "svc").
+		ServeOpenAPI(OpenAPIConf{ErrorBody: customErrorBody{}, DefaultErrorResponse: true}).
+		AddAPIs(api).OpenAPI()
+	s := doc.Components.Schemas[openapi.RefName(openapi.FieldErrorRef)]
+	if s == nil || s.Properties["field"] == nil || s.Properties["code"] != nil {
+		t.Fatalf("%s: %+v", openapi.FieldErrorRef, s)
+	}
+	src, err := codegen.GoClient(doc, codegen.GoConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "Fields  []ApiToolkitFieldError") {
+		t.Errorf("same-named type reused the builtin FieldError:\n%s", src)
+	}
+}
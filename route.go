@@ -0,0 +1,280 @@
+package apitool
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/wayne011872/api-toolkit/auth"
+	"github.com/wayne011872/api-toolkit/mid"
+)
+
+// GinApiHandler.Method 設為 MethodAny 時註冊所有標準 method
+const MethodAny = "ANY"
+
+var anyMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodHead, http.MethodOptions, http.MethodDelete,
+	http.MethodConnect, http.MethodTrace,
+}
+
+// 路由群組, 群組內 handler 與子群組的 Path 都相對於群組路徑
+type GinApiGroup struct {
+	Path string
+	// 掛在全域 middleware 之後, 外層群組先執行
+	Middles []mid.GinMiddle
+	// 為 true 時群組內所有路由都需要驗證, 設定 Public 的路由除外
+	Auth bool
+	// handler 沒有設定 Group 時套用
+	Group  []auth.ApiPerm
+	APIs   []*GinApiHandler
+	Groups []*GinApiGroup
+}
+
+// GinAPI 額外實作此介面時, GetAPIs 的 handler 掛在 GetGroup 回傳的群組下
+type GinGroupAPI interface {
+	GinAPI
+	GetGroup() *GinApiGroup
+}
+
+// 展開群組後的單一路由, handler 為套用群組設定後的複本
+// middles 為群組與路由自己的 middleware, 不含全域
+type ginRoute struct {
+	api     GinAPI
+	handler *GinApiHandler
+	middles []mid.GinMiddle
+}
+
+func flattenRoutes(api GinAPI) []*ginRoute {
+	var routes []*ginRoute
+	root := &GinApiGroup{}
+	if g, ok := api.(GinGroupAPI); ok && g.GetGroup() != nil {
+		root = g.GetGroup()
+	}
+	root.flatten(api, api.GetAPIs(), "", nil, false, nil, &routes)
+	return routes
+}
+
+func (g *GinApiGroup) flatten(api GinAPI, extra []*GinApiHandler, prefix string, middles []mid.GinMiddle,
+	isAuth bool, group []auth.ApiPerm, routes *[]*ginRoute) {
+	prefix = joinPath(prefix, g.Path)
+	middles = append(middles[:len(middles):len(middles)], g.Middles...)
+	isAuth = isAuth || g.Auth
+	if len(g.Group) > 0 {
+		group = g.Group
+	}
+	for _, h := range append(extra[:len(extra):len(extra)], g.APIs...) {
+		if h == nil {
+			continue
+		}
+		rh := *h
+		rh.Path = joinPath(prefix, h.Path)
+		if !h.Public {
+			rh.Auth = h.Auth || isAuth
+			if len(rh.Group) == 0 {
+				rh.Group = group
+			}
+		}
+		*routes = append(*routes, &ginRoute{
+			api:     api,
+			handler: &rh,
+			middles: append(middles[:len(middles):len(middles)], h.Middles...),
+		})
+	}
+	for _, sub := range g.Groups {
+		if sub != nil {
+			sub.flatten(api, nil, prefix, middles, isAuth, group, routes)
+		}
+	}
+}
+
+// 與 gin 相同, 保留 relative 結尾的斜線
+func joinPath(base, relative string) string {
+	if relative == "" {
+		return base
+	}
+	final := path.Join(base, relative)
+	if strings.HasSuffix(relative, "/") && !strings.HasSuffix(final, "/") {
+		return final + "/"
+	}
+	return final
+}
+
+// 合併 Method 與 Methods 並展開 MethodAny, 重複的只保留一個
+func (h *GinApiHandler) methods() ([]string, error) {
+	var ms []string
+	if h.Method != "" {
+		ms = append(ms, h.Method)
+	}
+	ms = append(ms, h.Methods...)
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("missing method")
+	}
+	var result []string
+	seen := map[string]bool{}
+	add := func(m string) {
+		if !seen[m] {
+			seen[m] = true
+			result = append(result, m)
+		}
+	}
+	for _, m := range ms {
+		if m == MethodAny {
+			for _, am := range anyMethods {
+				add(am)
+			}
+			continue
+		}
+		if !isValidMethod(m) {
+			return nil, fmt.Errorf("invalid method %q", m)
+		}
+		add(m)
+	}
+	return result, nil
+}
+
+// 錯誤訊息用, 未展開 MethodAny
+func (h *GinApiHandler) methodLabel() string {
+	ms := h.Methods
+	if h.Method != "" {
+		ms = append([]string{h.Method}, ms...)
+	}
+	if len(ms) == 0 {
+		return "-"
+	}
+	return strings.Join(ms, ",")
+}
+
+// 自訂 method (例如 WebDAV 的 PROPFIND) 只接受大寫英數與 - _, 小寫多半是打錯
+func isValidMethod(m string) bool {
+	if m == "" {
+		return false
+	}
+	for _, r := range m {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// 註冊路由時的錯誤, API 為 GinAPI 的型別名稱
+type RouteError struct {
+	API    string
+	Method string
+	Path   string
+	Err    error
+}
+
+func newRouteError(api GinAPI, method, path string, err error) *RouteError {
+	return &RouteError{
+		API:    fmt.Sprintf("%T", api),
+		Method: method,
+		Path:   path,
+		Err:    err,
+	}
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("%s [%s %s]: %v", e.API, e.Method, e.Path, e.Err)
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+type RouteErrors []*RouteError
+
+func (e RouteErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, re := range e {
+		lines[i] = re.Error()
+	}
+	return fmt.Sprintf("%d route error(s):\n  %s", len(e), strings.Join(lines, "\n  "))
+}
+
+type routeEntry struct {
+	path  string
+	owner string
+}
+
+// 以 method 分組記錄已註冊的路徑, 用來在交給 gin 之前找出重複與萬用字元衝突
+type routeTable map[string][]routeEntry
+
+func (t routeTable) add(method, p, owner string) error {
+	for _, e := range t[method] {
+		if e.path == p {
+			return fmt.Errorf("duplicate route, already registered by %s", e.owner)
+		}
+		if conflictWildcard(e.path, p) {
+			return fmt.Errorf("wildcard conflicts with %s registered by %s", e.path, e.owner)
+		}
+	}
+	t[method] = append(t[method], routeEntry{path: p, owner: owner})
+	return nil
+}
+
+// 逐段比對到第一個不同的段落: 不同名稱的參數或任一邊是 catch-all 都會讓 gin panic
+// 靜態段落與參數可以並存 (gin 1.8 起)
+func conflictWildcard(a, b string) bool {
+	sa, sb := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(sa) && i < len(sb); i++ {
+		x, y := sa[i], sb[i]
+		if x == y {
+			continue
+		}
+		if strings.HasPrefix(x, "*") || strings.HasPrefix(y, "*") {
+			return true
+		}
+		return strings.HasPrefix(x, ":") && strings.HasPrefix(y, ":")
+	}
+	return false
+}
+
+func (serv *ginApiServ) validateRoute(table routeTable, r *ginRoute) ([]string, []*RouteError) {
+	h := r.handler
+	var errs []*RouteError
+	fail := func(method string, err error) {
+		errs = append(errs, newRouteError(r.api, method, h.Path, err))
+	}
+	methods, err := h.methods()
+	if err != nil {
+		fail(h.methodLabel(), err)
+	}
+	if h.Handler == nil {
+		fail(h.methodLabel(), fmt.Errorf("nil handler"))
+	}
+	if !strings.HasPrefix(h.Path, "/") {
+		fail(h.methodLabel(), fmt.Errorf("path must begin with '/'"))
+	}
+	// MFA 只在 auth middleware 驗證身分後檢查, 沒有 Auth 時不會生效
+	if h.MfaMaxAge > 0 && !h.Auth {
+		fail(h.methodLabel(), fmt.Errorf("MfaMaxAge requires Auth"))
+	}
+	if h.Auth && h.Public {
+		fail(h.methodLabel(), fmt.Errorf("route cannot be both Auth and Public"))
+	}
+	if h.Auth && serv.perms != nil {
+		for _, p := range h.Group {
+			if !serv.perms[p] {
+				fail(h.methodLabel(), fmt.Errorf("unknown permission %q", p))
+			}
+		}
+	}
+	for _, m := range r.middles {
+		if m == nil {
+			fail(h.methodLabel(), fmt.Errorf("nil middleware"))
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	for _, method := range methods {
+		if err := table.add(method, h.Path, fmt.Sprintf("%T", r.api)); err != nil {
+			fail(method, err)
+		}
+	}
+	return methods, errs
+}
@@ -0,0 +1,189 @@
+package apitool
+
+import (
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/auth"
+	"github.com/wayne011872/api-toolkit/errors"
+	"github.com/wayne011872/api-toolkit/mid"
+)
+
+type groupTestAPI struct {
+	errors.CommonApiErrorHandler
+	group *GinApiGroup
+}
+
+func (a *groupTestAPI) GetAPIs() []*GinApiHandler {
+	return nil
+}
+
+func (a *groupTestAPI) GetGroup() *GinApiGroup {
+	return a.group
+}
+
+func TestFlattenPublicOverridesGroupAuth(t *testing.T) {
+	noop := func(c *gin.Context) {}
+	api := &groupTestAPI{group: &GinApiGroup{
+		Path:  "/v1",
+		Auth:  true,
+		Group: []auth.ApiPerm{"admin"},
+		APIs: []*GinApiHandler{
+			{Path: "/login", Method: "POST", Handler: noop, Public: true},
+			{Path: "/users", Method: "GET", Handler: noop},
+		},
+	}}
+	routes := flattenRoutes(api)
+	if len(routes) != 2 {
+		t.Fatalf("%d routes", len(routes))
+	}
+	if h := routes[0].handler; h.Auth || len(h.Group) != 0 {
+		t.Fatalf("public route %s: Auth=%v Group=%v", h.Path, h.Auth, h.Group)
+	}
+	if h := routes[1].handler; !h.Auth || len(h.Group) != 1 {
+		t.Fatalf("group route %s: Auth=%v Group=%v", h.Path, h.Auth, h.Group)
+	}
+}
+
+func TestValidateRouteMfaRequiresAuth(t *testing.T) {
+	serv := &ginApiServ{}
+	api := &groupTestAPI{}
+	r := &ginRoute{api: api, handler: &GinApiHandler{
+		Path: "/transfer", Method: "POST", Handler: func(c *gin.Context) {}, MfaMaxAge: time.Minute,
+	}}
+	if _, errs := serv.validateRoute(routeTable{}, r); len(errs) != 1 {
+		t.Fatalf("errs %v", errs)
+	}
+	r.handler.Auth = true
+	if _, errs := serv.validateRoute(routeTable{}, r); len(errs) != 0 {
+		t.Fatalf("errs %v", errs)
+	}
+}
+
+// 記錄執行順序的 auth middleware
+type orderAuthMid struct {
+	auth.GinAuthMidInter
+	order *[]string
+}
+
+func (m *orderAuthMid) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		*m.order = append(*m.order, "auth")
+	}
+}
+
+func orderMid(order *[]string, name string) mid.GinMiddle {
+	return mid.NewGinMiddle(func(c *gin.Context) {
+		*order = append(*order, name)
+	})
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		explicit bool
+		want     string
+	}{
+		{"implicit", false, "pre,auth,global1,global2,group,subgroup,route,handler"},
+		{"explicit", true, "pre,global1,auth,global2,group,subgroup,route,handler"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var order []string
+			authMid := &orderAuthMid{GinAuthMidInter: auth.NewMockAuthMid(), order: &order}
+			api := &groupTestAPI{group: &GinApiGroup{
+				Path:    "/v1",
+				Middles: []mid.GinMiddle{orderMid(&order, "group")},
+				Groups: []*GinApiGroup{{
+					Path:    "/users",
+					Middles: []mid.GinMiddle{orderMid(&order, "subgroup")},
+					APIs: []*GinApiHandler{{
+						Path: "/me", Method: "GET", Auth: true,
+						Middles: []mid.GinMiddle{orderMid(&order, "route")},
+						Handler: func(c *gin.Context) { order = append(order, "handler") },
+					}},
+				}},
+			}}
+			serv := NewGinApiServer(gin.TestMode, "svc").
+				SetServerErrorHandler(func(c *gin.Context, service string, err error) {
+					t.Error(err)
+				})
+			// 呼叫順序與執行順序無關
+			global := []mid.GinMiddle{orderMid(&order, "global1"), orderMid(&order, "global2")}
+			if tc.explicit {
+				global = []mid.GinMiddle{global[0], authMid, global[1]}
+			}
+			serv.AddAPIs(api).Middles(global...).SetAuth(authMid).PreAuthMiddles(orderMid(&order, "pre"))
+			if tc.explicit {
+				serv.Middles(authMid)
+			}
+			if err := serv.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			serv.GetServer(0).Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/users/me", nil))
+			if got := strings.Join(order, ","); got != tc.want {
+				t.Fatalf("order %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+type otherTestAPI struct {
+	groupTestAPI
+}
+
+func TestRouteErrors(t *testing.T) {
+	noop := func(c *gin.Context) {}
+	for _, tc := range []struct {
+		name  string
+		other []*GinApiHandler
+		want  string
+	}{
+		{"duplicate", []*GinApiHandler{{Path: "/users/:id", Method: "GET", Handler: noop}}, "duplicate route"},
+		{"wildcard", []*GinApiHandler{{Path: "/users/:name", Method: "GET", Handler: noop}}, "wildcard conflicts"},
+		{"catch-all", []*GinApiHandler{{Path: "/users/*any", Method: "GET", Handler: noop}}, "wildcard conflicts"},
+		{"method", []*GinApiHandler{{Path: "/items", Method: "get", Handler: noop}}, "invalid method"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newServer := func() GinApiServer {
+				return NewGinApiServer(gin.TestMode, "svc").
+					SetServerErrorHandler(func(c *gin.Context, service string, err error) {}).
+					AddAPIs(
+						&groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{{Path: "/users/:id", Method: "GET", Handler: noop}}}},
+						&otherTestAPI{groupTestAPI{group: &GinApiGroup{APIs: tc.other}}},
+					)
+			}
+			check := func(how string, err error) {
+				t.Helper()
+				var routeErrs RouteErrors
+				if !stderrors.As(err, &routeErrs) || len(routeErrs) != 1 {
+					t.Fatalf("%s: %v", how, err)
+				}
+				re := routeErrs[0]
+				if re.API != "*apitool.otherTestAPI" || re.Path != tc.other[0].Path || !strings.Contains(re.Error(), tc.want) {
+					t.Fatalf("%s: %v", how, re)
+				}
+			}
+
+			check("Err", newServer().Err())
+			check("Run", newServer().Run(0))
+			server, err := newServer().GetServerE(0)
+			if server != nil {
+				t.Fatal("GetServerE returned a server with route errors")
+			}
+			check("GetServerE", err)
+			func() {
+				defer func() {
+					err, _ := recover().(error)
+					check("GetServer", err)
+				}()
+				newServer().GetServer(0)
+			}()
+		})
+	}
+}
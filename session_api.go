@@ -0,0 +1,101 @@
+package apitool
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/auth"
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+// path 例如 /v1/sessions, 提供列出目前使用者的 session 及撤銷單一/全部 session
+func NewSessionAPI(store auth.SessionStore, path string, listeners ...auth.AuthEventListener) GinAPI {
+	return &sessionAPI{
+		store:     store,
+		path:      strings.TrimSuffix(path, "/"),
+		listeners: listeners,
+	}
+}
+
+type sessionAPI struct {
+	errors.CommonApiErrorHandler
+	store     auth.SessionStore
+	path      string
+	listeners auth.AuthEventListeners
+}
+
+func (a *sessionAPI) GetAPIs() []*GinApiHandler {
+	return []*GinApiHandler{
+		{Path: a.path, Handler: a.listHandler, Method: "GET", Auth: true},
+		{Path: a.path + "/:id", Handler: a.revokeHandler, Method: "DELETE", Auth: true},
+		{Path: a.path, Handler: a.revokeAllHandler, Method: "DELETE", Auth: true},
+	}
+}
+
+type sessionResp struct {
+	*auth.Session
+	Current bool `json:"current"`
+}
+
+func currentSessionId(u auth.ReqUser) string {
+	ext, ok := u.(auth.ReqUserExt)
+	if !ok {
+		return ""
+	}
+	v, _ := ext.GetClaim(auth.ClaimSessionId)
+	sid, _ := v.(string)
+	return sid
+}
+
+func (a *sessionAPI) listHandler(c *gin.Context) {
+	u := auth.GetReqUserFromGin(c)
+	if u == nil {
+		a.GinApiErrorHandler(c, errors.Error_Auth_Miss_Token)
+		return
+	}
+	sessions, err := a.store.List(u.GetId())
+	if err != nil {
+		a.GinApiErrorHandler(c, err)
+		return
+	}
+	sid := currentSessionId(u)
+	result := make([]*sessionResp, len(sessions))
+	for i, s := range sessions {
+		result[i] = &sessionResp{Session: s, Current: s.Id == sid}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *sessionAPI) revokeHandler(c *gin.Context) {
+	u := auth.GetReqUserFromGin(c)
+	if u == nil {
+		a.GinApiErrorHandler(c, errors.Error_Auth_Miss_Token)
+		return
+	}
+	err := a.store.Revoke(u.GetId(), c.Param("id"))
+	if err == auth.ErrSessionNotFound {
+		a.GinApiErrorHandler(c, errors.PkgError(http.StatusNotFound, err))
+		return
+	}
+	if err != nil {
+		a.GinApiErrorHandler(c, err)
+		return
+	}
+	a.listeners.OnAuthEvent(auth.NewAuthEventFromGin(c, auth.EventTokenRevoke, "session"))
+	c.Status(http.StatusNoContent)
+}
+
+func (a *sessionAPI) revokeAllHandler(c *gin.Context) {
+	u := auth.GetReqUserFromGin(c)
+	if u == nil {
+		a.GinApiErrorHandler(c, errors.Error_Auth_Miss_Token)
+		return
+	}
+	if err := a.store.RevokeAll(u.GetId()); err != nil {
+		a.GinApiErrorHandler(c, err)
+		return
+	}
+	a.listeners.OnAuthEvent(auth.NewAuthEventFromGin(c, auth.EventTokenRevoke, "all sessions"))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,236 @@
+package apitool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/errors"
+	"github.com/wayne011872/api-toolkit/mid"
+	"github.com/wayne011872/api-toolkit/openapi"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+type ValidateConf struct {
+	// 驗證回應是否符合文件, nil 時只在 gin debug 模式開啟
+	Response *bool
+	// 回應不符合文件時呼叫, 預設寫到 Logger; 測試中可改為 t.Error
+	OnResponseViolation func(c *gin.Context, status int, fields []errors.FieldError)
+	// 預設 OnResponseViolation 使用, AutoGinApiServer 預設為 Config.Logger, 都沒有時寫到 gin.DefaultErrorWriter
+	Logger Log
+	// 不驗證回應的 status, 例如 panic 時 recovery 回應的 500
+	IgnoreStatus []int
+	// 驗證時讀取的 JSON body 上限, 預設 1 MiB, 超過時回應 413
+	MaxBodyBytes int64
+}
+
+// 依 serv 產生的 OpenAPI 文件驗證請求的 path/query/header 參數與 JSON body
+// 不符合時以 errors.FieldErrors 交給 error handler, 不會執行 handler
+// 文件中沒有的路由 (例如 Hidden) 不驗證, 需以 Middles 加入才會排在 auth 之後
+func NewGinValidateMid(serv GinApiServer, conf ValidateConf) mid.GinMiddle {
+	if conf.OnResponseViolation == nil {
+		conf.OnResponseViolation = newViolationLogger(conf.Logger)
+	}
+	if conf.MaxBodyBytes <= 0 {
+		conf.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	response := gin.Mode() == gin.DebugMode
+	if conf.Response != nil {
+		response = *conf.Response
+	}
+	return &validateMiddle{serv: serv, conf: conf, response: response}
+}
+
+type validateMiddle struct {
+	errors.CommonApiErrorHandler
+	serv     GinApiServer
+	conf     ValidateConf
+	response bool
+
+	once      sync.Once
+	doc       *openapi.Document
+	validator *openapi.Validator
+}
+
+// 第一個請求時路由都已註冊, 之後沿用同一份文件
+func (m *validateMiddle) operation(c *gin.Context) *openapi.Operation {
+	m.once.Do(func() {
+		m.doc = m.serv.OpenAPI()
+		m.validator = openapi.NewValidator(m.doc)
+	})
+	if c.FullPath() == "" {
+		return nil
+	}
+	p, _ := toOpenAPIPath(c.FullPath())
+	item := m.doc.Paths[p]
+	if item == nil {
+		return nil
+	}
+	return (*item)[strings.ToLower(c.Request.Method)]
+}
+
+func (m *validateMiddle) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := m.operation(c)
+		if op == nil {
+			c.Next()
+			return
+		}
+		fields, err := m.validateRequest(c, op)
+		if err == nil && len(fields) > 0 {
+			err = errors.NewValidationError(fields)
+		}
+		if err != nil {
+			m.GinApiErrorHandler(c, err)
+			c.Abort()
+			return
+		}
+		if !m.response {
+			c.Next()
+			return
+		}
+		w := &bodyCopyWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		if fields := m.validateResponse(c, op, w.body.Bytes()); len(fields) > 0 {
+			m.conf.OnResponseViolation(c, c.Writer.Status(), fields)
+		}
+	}
+}
+
+func (m *validateMiddle) validateRequest(c *gin.Context, op *openapi.Operation) ([]errors.FieldError, error) {
+	var fields []errors.FieldError
+	for _, in := range []string{openapi.InPath, openapi.InQuery, openapi.InHeader} {
+		for _, p := range op.Parameters {
+			if p.In != in {
+				continue
+			}
+			var values []string
+			switch in {
+			case openapi.InPath:
+				if v := c.Param(p.Name); v != "" {
+					values = []string{v}
+				}
+			case openapi.InQuery:
+				values = c.QueryArray(p.Name)
+			case openapi.InHeader:
+				values = c.Request.Header.Values(p.Name)
+			}
+			fields = appendViolations(fields, in, m.validator.ValidateParam(p, values))
+		}
+	}
+	if op.RequestBody == nil {
+		return fields, nil
+	}
+	media := op.RequestBody.Content[openapi.MimeJSON]
+	if media == nil || !isJSONContent(c.ContentType()) {
+		return fields, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, m.conf.MaxBodyBytes))
+	if err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return nil, errors.Error_Request_Too_Large
+		}
+		return append(fields, errors.FieldError{In: errors.InBody, Message: err.Error()}), nil
+	}
+	c.Request.Body.Close()
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			fields = append(fields, errors.FieldError{In: errors.InBody, Message: "required"})
+		}
+		return fields, nil
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return append(fields, errors.FieldError{In: errors.InBody, Message: "invalid JSON: " + err.Error()}), nil
+	}
+	return appendViolations(fields, errors.InBody, m.validator.Validate(media.Schema, value)), nil
+}
+
+func (m *validateMiddle) validateResponse(c *gin.Context, op *openapi.Operation, body []byte) []errors.FieldError {
+	status := c.Writer.Status()
+	for _, s := range m.conf.IgnoreStatus {
+		if s == status {
+			return nil
+		}
+	}
+	// 沒有對應 status 時以文件的 default 回應驗證
+	resp := op.Responses[strconv.Itoa(status)]
+	if resp == nil {
+		resp = op.Responses[openapi.DefaultResponse]
+	}
+	if resp == nil {
+		return []errors.FieldError{{In: errors.InBody, Message: "undocumented status " + strconv.Itoa(status)}}
+	}
+	media := resp.Content[openapi.MimeJSON]
+	if media == nil || len(bytes.TrimSpace(body)) == 0 || !isJSONContent(c.Writer.Header().Get("Content-Type")) {
+		return nil
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return []errors.FieldError{{In: errors.InBody, Message: "invalid JSON: " + err.Error()}}
+	}
+	return appendViolations(nil, errors.InBody, m.validator.Validate(media.Schema, value))
+}
+
+func appendViolations(fields []errors.FieldError, in string, vs []openapi.Violation) []errors.FieldError {
+	for _, v := range vs {
+		fields = append(fields, errors.FieldError{Field: v.Field, In: in, Message: v.Message})
+	}
+	return fields
+}
+
+// 保留數字原文, 大整數不會因為轉成 float64 而誤判
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// 沒有 Content-Type 時視為 JSON
+func isJSONContent(ct string) bool {
+	ct, _, _ = strings.Cut(ct, ";")
+	ct = strings.TrimSpace(ct)
+	return ct == "" || ct == openapi.MimeJSON || strings.HasSuffix(ct, "+json")
+}
+
+func newViolationLogger(l Log) func(c *gin.Context, status int, fields []errors.FieldError) {
+	logf := func(format string, a ...any) {
+		fmt.Fprintf(gin.DefaultErrorWriter, format+"\n", a...)
+	}
+	if l != nil {
+		logf = l.Infof
+	}
+	return func(c *gin.Context, status int, fields []errors.FieldError) {
+		for _, f := range fields {
+			logf("openapi: %s %s response %d: %s %s: %s", c.Request.Method, c.FullPath(), status, f.In, f.Field, f.Message)
+		}
+	}
+}
+
+type bodyCopyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCopyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCopyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
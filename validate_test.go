@@ -0,0 +1,159 @@
+package apitool
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wayne011872/api-toolkit/errors"
+)
+
+type validateReq struct {
+	Name string `json:"name"`
+}
+
+type validateResp struct {
+	Id int `json:"id"`
+}
+
+func newValidateServer(conf ValidateConf, resp string) http.Handler {
+	api := &groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{{
+		Path: "/users", Method: "POST",
+		Handler: func(c *gin.Context) {
+			c.Data(http.StatusOK, "application/json", []byte(resp))
+		},
+		Doc: &ApiDoc{Request: validateReq{}, Response: validateResp{}},
+	}}}}
+	serv := NewGinApiServer(gin.TestMode, "svc")
+	serv.SetServerErrorHandler(func(c *gin.Context, service string, err error) {
+		status := http.StatusInternalServerError
+		if apiErr, ok := err.(errors.ApiError); ok {
+			status = apiErr.GetStatus()
+		}
+		c.AbortWithStatus(status)
+	})
+	serv.Middles(NewGinValidateMid(serv, conf)).AddAPIs(api)
+	return serv.GetServer(0).Handler
+}
+
+func postJSON(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestValidateBodyLimit(t *testing.T) {
+	h := newValidateServer(ValidateConf{MaxBodyBytes: 32}, `{"id":1}`)
+	if w := postJSON(h, `{"name":"amy"}`); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	w := postJSON(h, `{"name":"`+strings.Repeat("a", 64)+`"}`)
+	if w.Code != errors.Error_Request_Too_Large.GetStatus() {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestValidateResponseMode(t *testing.T) {
+	enabled, disabled := true, false
+	for _, tc := range []struct {
+		name     string
+		response *bool
+		want     bool
+	}{
+		{"unset in test mode", nil, false},
+		{"explicit true", &enabled, true},
+		{"explicit false", &disabled, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			violated := false
+			h := newValidateServer(ValidateConf{
+				Response: tc.response,
+				OnResponseViolation: func(c *gin.Context, status int, fields []errors.FieldError) {
+					violated = true
+				},
+			}, `{"id":"not a number"}`)
+			postJSON(h, `{"name":"amy"}`)
+			if violated != tc.want {
+				t.Fatalf("response validated = %v", violated)
+			}
+		})
+	}
+}
+
+type recordLog struct {
+	lines []string
+}
+
+func (l *recordLog) Infof(format string, a ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, a...))
+}
+
+func (l *recordLog) Fatalf(format string, a ...any) {
+	panic(fmt.Sprintf(format, a...))
+}
+
+func TestValidateResponseLogger(t *testing.T) {
+	enabled := true
+	l := &recordLog{}
+	h := newValidateServer(ValidateConf{Response: &enabled, Logger: l}, `{"id":"not a number"}`)
+	postJSON(h, `{"name":"amy"}`)
+	if len(l.lines) != 1 || !strings.Contains(l.lines[0], "POST /users response 200") {
+		t.Fatalf("log %q", l.lines)
+	}
+}
+
+// handler 以 500 回應錯誤 body, 例如 error handler 處理 panic 的結果
+func TestValidateResponseUndocumentedStatus(t *testing.T) {
+	enabled := true
+	errBody := `{"status":500,"error":"internal error","service":"svc","errorKey":"internal"}`
+	for _, tc := range []struct {
+		name       string
+		conf       ValidateConf
+		defaultErr bool
+		body       string
+		want       string
+	}{
+		{"undocumented", ValidateConf{}, false, errBody, "undocumented status 500"},
+		{"ignored status", ValidateConf{IgnoreStatus: []int{http.StatusInternalServerError}}, false, `not json`, ""},
+		{"default response", ValidateConf{}, true, errBody, ""},
+		{"default response mismatch", ValidateConf{}, true, `{"status":"500"}`, "status"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var violations []errors.FieldError
+			conf := tc.conf
+			conf.Response = &enabled
+			conf.OnResponseViolation = func(c *gin.Context, status int, fields []errors.FieldError) {
+				violations = append(violations, fields...)
+			}
+			api := &groupTestAPI{group: &GinApiGroup{APIs: []*GinApiHandler{{
+				Path: "/users", Method: "GET",
+				Handler: func(c *gin.Context) {
+					c.Data(http.StatusInternalServerError, "application/json", []byte(tc.body))
+				},
+				Doc: &ApiDoc{Response: validateResp{}},
+			}}}}
+			serv := NewGinApiServer(gin.TestMode, "svc").
+				SetServerErrorHandler(func(c *gin.Context, service string, err error) {
+					t.Error(err)
+				}).
+				ServeOpenAPI(OpenAPIConf{DefaultErrorResponse: tc.defaultErr})
+			serv.Middles(NewGinValidateMid(serv, conf)).AddAPIs(api)
+			serv.GetServer(0).Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+			if tc.want == "" {
+				if len(violations) > 0 {
+					t.Fatalf("violations %+v", violations)
+				}
+				return
+			}
+			if len(violations) == 0 || !strings.Contains(violations[0].Field+" "+violations[0].Message, tc.want) {
+				t.Fatalf("violations %+v, want %q", violations, tc.want)
+			}
+		})
+	}
+}